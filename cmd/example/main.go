@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/marccanlas/phoenix-sdk-migration/lifinity"
+	"github.com/marccanlas/phoenix-sdk-migration/phoenix"
+	"github.com/marccanlas/phoenix-sdk-migration/venue"
+)
+
+func main() {
+	runPhoenix()
+	runLifinity()
+	runRouter()
+}
+
+func runPhoenix() {
+	hoenix := &phoenix.Hoenix{}
+	hoenix.Data.TakerFeeBps = 5
+
+	ladder := phoenix.UiLadder{
+		Bids: []phoenix.UiLadderLevel{
+			{Price: 20, Quantity: 10},
+			{Price: 15, Quantity: 5},
+			{Price: 10, Quantity: 2},
+		},
+		Asks: []phoenix.UiLadderLevel{
+			{Price: 25, Quantity: 10},
+			{Price: 30, Quantity: 5},
+			{Price: 35, Quantity: 2},
+		},
+	}
+
+	quoteParams1 := phoenix.QuoteParams{
+		InAmount: 150, // Buy x SOL
+		AToB:     true,
+	}
+
+	q1, updatedLadder1, err := hoenix.GetQuote(quoteParams1, &ladder)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Quote 1 (x SOL buy): %+v\n", q1)
+	fmt.Printf("Updated Ladder after x SOL buy: %+v\n", updatedLadder1)
+
+	quoteParams2 := phoenix.QuoteParams{
+		InAmount: 50, // Buy y SOL
+		AToB:     true,
+	}
+	q2, updatedLadder2, err := hoenix.GetQuote(quoteParams2, &ladder)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Quote 2 (y SOL buy): %+v\n", q2)
+	fmt.Printf("Updated Ladder after y SOL buy: %+v\n", updatedLadder2)
+}
+
+func runLifinity() {
+	liquidity := lifinity.NewLifinityLiquidity(1000, 20000)
+
+	// First swap (SOL to USDC)
+	params := lifinity.QuoteParams{
+		InAmount: 10,   // Input 10 SOL
+		AToB:     true, // Swap from SOL to USDC
+	}
+
+	quote, err := liquidity.GetQuote(params)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Quote 1: InAmount=%d, OutAmount=%d, PriceImpactBP=%d\n", quote.InAmount, quote.OutAmount, quote.PriceImpactBP)
+	fmt.Printf("Updated Liquidity after 1st swap: A=%d, B=%d\n", liquidity.A, liquidity.B)
+
+	// Second swap (USDC to SOL), now based on the updated liquidity
+	params1 := lifinity.QuoteParams{
+		InAmount: 500,   // Input 500 USDC
+		AToB:     false, // Swap from USDC to SOL
+	}
+
+	quote1, err := liquidity.GetQuote(params1)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Quote 2: InAmount=%d, OutAmount=%d, PriceImpactBP=%d\n", quote1.InAmount, quote1.OutAmount, quote1.PriceImpactBP)
+	fmt.Printf("Updated Liquidity after 2nd swap: A=%d, B=%d\n", liquidity.A, liquidity.B)
+}
+
+func runRouter() {
+	hoenix := &phoenix.Hoenix{
+		Ladder: &phoenix.UiLadder{
+			Bids: []phoenix.UiLadderLevel{{Price: 20, Quantity: 10}, {Price: 19, Quantity: 10}},
+			Asks: []phoenix.UiLadderLevel{{Price: 21, Quantity: 10}, {Price: 22, Quantity: 10}},
+		},
+	}
+	hoenix.Data.TakerFeeBps = 5
+	hoenix.Data.Header.BaseParams.Decimals = 9  // SOL
+	hoenix.Data.Header.QuoteParams.Decimals = 6 // USDC
+
+	// Lifinity reserves are raw units; the decimals let Quote take and return
+	// amounts comparable with Phoenix's
+	pool := lifinity.NewLifinityLiquidity(1000_000_000_000, 20000_000_000)
+	pool.DecimalsA, pool.DecimalsB = 9, 6
+
+	names := []string{"phoenix", "lifinity"}
+	venues := []venue.Quoter{hoenix, pool}
+
+	// Sell the same 5 base on every venue and keep the best proceeds
+	in := venue.Amount{Mantissa: 5, Decimals: 0}
+	best, bestOut := "", venue.Amount{}
+	for i, quoter := range venues {
+		name := names[i]
+		quote, err := quoter.Quote(in, true)
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			continue
+		}
+		fmt.Printf("%s: %v base -> %v quote\n", name, in, quote.OutAmount)
+		if quote.OutAmount.Cmp(bestOut) > 0 {
+			best, bestOut = name, quote.OutAmount
+		}
+	}
+	fmt.Printf("Best venue: %s\n", best)
+
+	// Two hops: sell token X for base on a Lifinity pool, then the base for
+	// quote on Phoenix
+	xPool := lifinity.NewLifinityLiquidity(100_000_000_000, 1000_000_000_000)
+	xPool.DecimalsA, xPool.DecimalsB = 6, 9
+	route := venue.Route{Legs: []venue.Leg{
+		{Quoter: xPool, AToB: true},
+		{Quoter: hoenix, AToB: true},
+	}}
+	routed, err := route.QuoteRoute(venue.Amount{Mantissa: 1000, Decimals: 0})
+	if err != nil {
+		fmt.Println("Route:", err)
+		return
+	}
+	fmt.Printf("Route: 1000 X -> %v base -> %v quote (fees %v bps, impact %d bps)\n",
+		routed.Legs[0].OutAmount, routed.OutAmount, routed.FeeBps, routed.PriceImpactBP)
+}
@@ -0,0 +1,371 @@
+// Package crossvenue compares a Phoenix book with a Lifinity pool trading the
+// same pair. It imports both venues, which the venue package cannot.
+//
+// Amounts are in whole tokens, as on a Phoenix ladder; the pool's raw units
+// are scaled by its DecimalsA and DecimalsB. aToB follows venue.Quoter: true
+// sells base (A) for quote (B) and false buys base with quote. Neither the book
+// nor the pool is ever modified.
+package crossvenue
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/marccanlas/phoenix-sdk-migration/lifinity"
+	"github.com/marccanlas/phoenix-sdk-migration/phoenix"
+	"github.com/marccanlas/phoenix-sdk-migration/venue"
+)
+
+// Venue names one of the two venues.
+type Venue int
+
+const (
+	Phoenix Venue = iota
+	Lifinity
+)
+
+func (v Venue) String() string {
+	if v == Phoenix {
+		return "phoenix"
+	}
+	return "lifinity"
+}
+
+// other returns the venue that is not v.
+func (v Venue) other() Venue {
+	if v == Phoenix {
+		return Lifinity
+	}
+	return Phoenix
+}
+
+// Result is one venue's side of a Comparison.
+type Result struct {
+	OutAmount     float64 // Whole tokens received, fees deducted
+	FeeBps        float64 // Venue fee charged on the swap
+	PriceImpactBP uint    // Price impact as the venue reports it
+	// Average price in quote per base made worse by the venue's
+	// LatencyPenaltyBps, from its LatencyAdjustedPrice
+	AdjustedPrice float64
+	Err           error // Why the venue could not quote; the other fields are then zero
+}
+
+// Comparison sets the two venues' quotes for the same swap side by side.
+type Comparison struct {
+	Phoenix  Result
+	Lifinity Result
+	Winner   Venue
+	// How much better the winner's AdjustedPrice is than the other venue's, in
+	// bps of the latter; zero when only one venue could quote
+	MarginBps float64
+}
+
+// Compare quotes amount in the direction aToB on the book, with market's fees,
+// and on the pool, and ranks the venues on their latency-adjusted prices: the
+// higher price wins a sale of base and the lower a purchase. A venue with a
+// smaller LatencyPenaltyBps can so win at a slightly worse raw price. A venue
+// that cannot quote, for instance for lack of liquidity, has its Err set and
+// the other venue wins; an error is returned only when both fail.
+func Compare(amount float64, aToB bool, market *phoenix.Hoenix, book *phoenix.UiLadder, pool *lifinity.LifinityLiquidity) (*Comparison, error) {
+	if !(amount > 0) || math.IsInf(amount, 0) {
+		return nil, fmt.Errorf("invalid amount %v", amount)
+	}
+
+	c := &Comparison{
+		Phoenix:  quotePhoenix(amount, aToB, market, book),
+		Lifinity: quoteLifinity(amount, aToB, pool),
+	}
+	switch {
+	case c.Phoenix.Err != nil && c.Lifinity.Err != nil:
+		return nil, fmt.Errorf("phoenix: %w; lifinity: %w", c.Phoenix.Err, c.Lifinity.Err)
+	case c.Phoenix.Err != nil:
+		c.Winner = Lifinity
+	case c.Lifinity.Err != nil:
+		c.Winner = Phoenix
+	default:
+		best, other := c.Phoenix.AdjustedPrice, c.Lifinity.AdjustedPrice
+		if (aToB && other > best) || (!aToB && other < best) {
+			c.Winner = Lifinity
+			best, other = other, best
+		}
+		c.MarginBps = math.Abs(best-other) / other * 10_000
+	}
+	return c, nil
+}
+
+// GeomeanPrice returns the geometric mean of the book's mid price and the
+// pool's spot price, both in whole quote tokens per whole base token, for use
+// as a composite oracle price. It fails when either price is unavailable.
+func GeomeanPrice(book *phoenix.UiLadder, pool *lifinity.LifinityLiquidity) (float64, error) {
+	mid, ok := book.MidPrice()
+	if !ok {
+		return 0, errors.New("book has no mid price")
+	}
+	spot, ok := spotPrice(pool)
+	if !ok {
+		return 0, errors.New("pool has an empty reserve")
+	}
+	return math.Sqrt(mid * spot), nil
+}
+
+// spotPrice returns the pool's spot price in whole quote tokens per whole base
+// token, scaling its raw B/A by the token decimals.
+func spotPrice(pool *lifinity.LifinityLiquidity) (float64, bool) {
+	if pool.A == 0 || pool.B == 0 {
+		return 0, false
+	}
+	baseInQuote, _ := pool.Prices()
+	return baseInQuote * math.Pow10(pool.DecimalsA-pool.DecimalsB), true
+}
+
+// CrossVenueSpreadBps returns the edge, in bps of the cost, of buying size
+// base on one venue and selling it on the other, taking the better of the two
+// directions. Both legs pay their venue's fees. The edge is negative when
+// neither direction pays.
+func CrossVenueSpreadBps(size float64, market *phoenix.Hoenix, book *phoenix.UiLadder, pool *lifinity.LifinityLiquidity) (float64, error) {
+	if !(size > 0) || math.IsInf(size, 0) {
+		return 0, fmt.Errorf("invalid size %v", size)
+	}
+
+	best, found := 0.0, false
+	var lastErr error
+	for _, buyOn := range []Venue{Phoenix, Lifinity} {
+		cost, proceeds, err := roundTrip(size, buyOn, market, book, pool)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if edge := (proceeds - cost) / cost * 10_000; !found || edge > best {
+			best, found = edge, true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no direction can trade size %v: %w", size, lastErr)
+	}
+	return best, nil
+}
+
+// arbSearchSteps is the number of evenly spaced sizes FindArbitrage tries.
+const arbSearchSteps = 100
+
+// ArbOpportunity is a round trip that buys base on one venue and sells it on
+// the other at a profit.
+type ArbOpportunity struct {
+	Size     float64 // Base bought on BuyOn and sold on SellOn
+	BuyOn    Venue
+	SellOn   Venue
+	Cost     float64 // Quote spent on the buy, fees included
+	Proceeds float64 // Quote received from the sale, fees deducted
+	Profit   float64 // Proceeds minus Cost
+}
+
+// FindArbitrage looks for the most profitable round trip of up to maxSize base
+// between the book and the pool, after both venues' fees. It tries
+// arbSearchSteps evenly spaced sizes in both directions, so the size found is
+// accurate to maxSize/arbSearchSteps. It returns a nil opportunity when no size
+// turns a profit, and an error only when no size could be priced at all.
+func FindArbitrage(market *phoenix.Hoenix, book *phoenix.UiLadder, pool *lifinity.LifinityLiquidity, maxSize float64) (*ArbOpportunity, error) {
+	if !(maxSize > 0) || math.IsInf(maxSize, 0) {
+		return nil, fmt.Errorf("invalid maximum size %v", maxSize)
+	}
+
+	var best *ArbOpportunity
+	priced := false
+	var lastErr error
+	for step := 1; step <= arbSearchSteps; step++ {
+		size := maxSize * float64(step) / arbSearchSteps
+		for _, buyOn := range []Venue{Phoenix, Lifinity} {
+			cost, proceeds, err := roundTrip(size, buyOn, market, book, pool)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			priced = true
+			if profit := proceeds - cost; profit > 0 && (best == nil || profit > best.Profit) {
+				best = &ArbOpportunity{
+					Size:     size,
+					BuyOn:    buyOn,
+					SellOn:   buyOn.other(),
+					Cost:     cost,
+					Proceeds: proceeds,
+					Profit:   profit,
+				}
+			}
+		}
+	}
+	if !priced {
+		return nil, fmt.Errorf("no size up to %v could be priced: %w", maxSize, lastErr)
+	}
+	return best, nil
+}
+
+// roundTrip buys size base on buyOn and sells it on the other venue. It returns
+// the quote spent and received, fees included.
+func roundTrip(size float64, buyOn Venue, market *phoenix.Hoenix, book *phoenix.UiLadder, pool *lifinity.LifinityLiquidity) (cost, proceeds float64, err error) {
+	if buyOn == Phoenix {
+		if cost, err = phoenixBuyCost(size, market, book); err != nil {
+			return 0, 0, err
+		}
+		proceeds, err = lifinitySellProceeds(size, pool)
+		return cost, proceeds, err
+	}
+	if cost, err = lifinityBuyCost(size, pool); err != nil {
+		return 0, 0, err
+	}
+	proceeds, err = phoenixSellProceeds(size, market, book)
+	return cost, proceeds, err
+}
+
+// phoenixBuyCost returns the quote, fee included, that buys size base.
+func phoenixBuyCost(size float64, market *phoenix.Hoenix, book *phoenix.UiLadder) (float64, error) {
+	quote, err := market.GetQuoteExactOut(size, true, book)
+	if err != nil {
+		return 0, err
+	}
+	return quote.InAmount, nil
+}
+
+// phoenixSellProceeds returns the quote, fee deducted, that selling size base pays.
+func phoenixSellProceeds(size float64, market *phoenix.Hoenix, book *phoenix.UiLadder) (float64, error) {
+	quote, err := market.GetQuoteReadOnly(phoenix.QuoteParams{InAmount: size}, book)
+	if err != nil {
+		return 0, err
+	}
+	return quote.OutAmount, nil
+}
+
+// lifinityBuyCost returns the quote, fee included, that buys size base from
+// the pool. The exact-output swap runs on a read-only copy.
+func lifinityBuyCost(size float64, pool *lifinity.LifinityLiquidity) (float64, error) {
+	out, err := toRaw(size, pool.DecimalsA)
+	if err != nil {
+		return 0, err
+	}
+	simulated := *pool
+	simulated.ReadOnly = true
+	quote, err := simulated.GetQuoteExactOut(out, false)
+	if err != nil {
+		return 0, err
+	}
+	return fromRaw(quote.InAmount, pool.DecimalsB), nil
+}
+
+// lifinitySellProceeds returns the quote, fee deducted, that selling size base
+// to the pool pays.
+func lifinitySellProceeds(size float64, pool *lifinity.LifinityLiquidity) (float64, error) {
+	result := quoteLifinity(size, true, pool)
+	return result.OutAmount, result.Err
+}
+
+// OptimalSplit divides amount between the book and the pool to maximize the
+// total output. It hands out amount in steps equal increments, each to the
+// venue whose output grows the most from taking it, which is optimal as long as
+// both venues' marginal prices only worsen with size. The returned quotes are
+// priced at each venue's final allocation and together spend amount, up to the
+// pool's raw unit; a venue that got nothing has a nil quote.
+func OptimalSplit(amount float64, aToB bool, market *phoenix.Hoenix, book *phoenix.UiLadder, pool *lifinity.LifinityLiquidity, steps int) (bookQuote *phoenix.Quote, poolQuote *lifinity.Quote, err error) {
+	if !(amount > 0) || math.IsInf(amount, 0) {
+		return nil, nil, fmt.Errorf("invalid amount %v", amount)
+	}
+	if steps <= 0 {
+		return nil, nil, errors.New("steps must be greater than zero")
+	}
+
+	chunk := amount / float64(steps)
+	var bookIn, bookOut, poolIn, poolOut float64
+	for step := 0; step < steps; step++ {
+		size := chunk
+		if step == steps-1 {
+			// Absorb float drift so the allocations sum to amount
+			size = amount - bookIn - poolIn
+		}
+
+		onBook := quotePhoenix(bookIn+size, aToB, market, book)
+		onPool := quoteLifinity(poolIn+size, aToB, pool)
+		switch {
+		case onBook.Err != nil && onPool.Err != nil:
+			return nil, nil, fmt.Errorf("neither venue can fill step %d of %d: phoenix: %w; lifinity: %w", step+1, steps, onBook.Err, onPool.Err)
+		case onPool.Err != nil || (onBook.Err == nil && onBook.OutAmount-bookOut >= onPool.OutAmount-poolOut):
+			bookIn, bookOut = bookIn+size, onBook.OutAmount
+		default:
+			poolIn, poolOut = poolIn+size, onPool.OutAmount
+		}
+	}
+
+	if bookIn > 0 {
+		if bookQuote, err = market.GetQuoteReadOnly(phoenix.QuoteParams{InAmount: bookIn, AToB: !aToB}, book); err != nil {
+			return nil, nil, err
+		}
+	}
+	if poolIn > 0 {
+		inDecimals := pool.DecimalsB
+		if aToB {
+			inDecimals = pool.DecimalsA
+		}
+		in, err := toRaw(poolIn, inDecimals)
+		if err != nil {
+			return nil, nil, err
+		}
+		if poolQuote, err = pool.SimulateQuote(lifinity.QuoteParams{InAmount: in, AToB: aToB}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return bookQuote, poolQuote, nil
+}
+
+// quotePhoenix prices amount against a copy of book.
+func quotePhoenix(amount float64, aToB bool, market *phoenix.Hoenix, book *phoenix.UiLadder) Result {
+	// QuoteParams.AToB buys base, the opposite of aToB
+	quote, err := market.GetQuoteReadOnly(phoenix.QuoteParams{InAmount: amount, AToB: !aToB}, book)
+	if err != nil {
+		return Result{Err: err}
+	}
+	return Result{
+		OutAmount:     quote.OutAmount,
+		FeeBps:        quote.FeeBps,
+		PriceImpactBP: quote.PriceImpactBP,
+		AdjustedPrice: market.LatencyAdjustedPrice(quote),
+	}
+}
+
+// quoteLifinity prices amount against the pool without executing it.
+func quoteLifinity(amount float64, aToB bool, pool *lifinity.LifinityLiquidity) Result {
+	inDecimals, outDecimals := pool.DecimalsB, pool.DecimalsA
+	if aToB {
+		inDecimals, outDecimals = pool.DecimalsA, pool.DecimalsB
+	}
+	in, err := toRaw(amount, inDecimals)
+	if err != nil {
+		return Result{Err: err}
+	}
+	quote, err := pool.SimulateQuote(lifinity.QuoteParams{InAmount: in, AToB: aToB})
+	if err != nil {
+		return Result{Err: err}
+	}
+	return Result{
+		OutAmount:     fromRaw(quote.OutAmount, outDecimals),
+		FeeBps:        float64(pool.FeeBps),
+		PriceImpactBP: quote.PriceImpactBP,
+		// The pool's price is raw B per raw A
+		AdjustedPrice: pool.LatencyAdjustedPrice(quote) * math.Pow10(pool.DecimalsA-pool.DecimalsB),
+	}
+}
+
+// toRaw converts whole tokens to raw units of a token with the given decimals,
+// rounding to the nearest unit.
+func toRaw(units float64, decimals int) (uint64, error) {
+	amount, err := venue.FromFloat(units, decimals)
+	if err != nil {
+		return 0, err
+	}
+	if amount.Sign() <= 0 {
+		return 0, fmt.Errorf("amount %v is less than one unit of a token with %d decimals", units, decimals)
+	}
+	return uint64(amount.Mantissa), nil
+}
+
+// fromRaw converts raw units of a token with the given decimals to whole tokens.
+func fromRaw(raw uint64, decimals int) float64 {
+	return float64(raw) / math.Pow10(decimals)
+}
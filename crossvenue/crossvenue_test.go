@@ -0,0 +1,280 @@
+package crossvenue
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/marccanlas/phoenix-sdk-migration/lifinity"
+	"github.com/marccanlas/phoenix-sdk-migration/phoenix"
+)
+
+func sampleMarket() *phoenix.Hoenix {
+	market := &phoenix.Hoenix{}
+	market.Data.TakerFeeBps = 5
+	return market
+}
+
+func sampleBook() *phoenix.UiLadder {
+	return &phoenix.UiLadder{
+		Bids: []phoenix.UiLadderLevel{{Price: 20, Quantity: 10}, {Price: 15, Quantity: 5}, {Price: 10, Quantity: 2}},
+		Asks: []phoenix.UiLadderLevel{{Price: 25, Quantity: 10}, {Price: 30, Quantity: 5}, {Price: 35, Quantity: 2}},
+	}
+}
+
+// newPool returns a pool holding base and quote whole tokens of a 9-decimal
+// base and a 6-decimal quote.
+func newPool(base, quote float64) *lifinity.LifinityLiquidity {
+	pool := lifinity.NewLifinityLiquidity(uint64(base*1e9), uint64(quote*1e6))
+	pool.DecimalsA, pool.DecimalsB = 9, 6
+	return pool
+}
+
+func TestCompareBothSucceed(t *testing.T) {
+	book, pool := sampleBook(), newPool(1000, 22_500)
+	c, err := Compare(1, true, sampleMarket(), book, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Phoenix.Err != nil || c.Lifinity.Err != nil {
+		t.Fatalf("unexpected venue errors: %v, %v", c.Phoenix.Err, c.Lifinity.Err)
+	}
+
+	// Selling 1 base hits the 20 bid less 5 bps; the pool pays about 22.36
+	if math.Abs(c.Phoenix.OutAmount-19.99) > 1e-9 {
+		t.Errorf("phoenix out = %v, want 19.99", c.Phoenix.OutAmount)
+	}
+	if c.Phoenix.FeeBps != 5 || c.Lifinity.FeeBps != lifinity.LifinityFeeRate {
+		t.Errorf("fees = %v, %v", c.Phoenix.FeeBps, c.Lifinity.FeeBps)
+	}
+	if c.Winner != Lifinity {
+		t.Errorf("winner = %v, want lifinity", c.Winner)
+	}
+	// Without latency penalties the adjusted prices are the raw average prices
+	if math.Abs(c.Phoenix.AdjustedPrice-19.99) > 1e-9 || math.Abs(c.Lifinity.AdjustedPrice-c.Lifinity.OutAmount) > 1e-9 {
+		t.Errorf("adjusted prices = %v, %v", c.Phoenix.AdjustedPrice, c.Lifinity.AdjustedPrice)
+	}
+	want := (c.Lifinity.AdjustedPrice - c.Phoenix.AdjustedPrice) / c.Phoenix.AdjustedPrice * 10_000
+	if math.Abs(c.MarginBps-want) > 1e-9 || c.MarginBps <= 0 {
+		t.Errorf("margin = %v bps, want %v", c.MarginBps, want)
+	}
+
+	if book.Bids[0].Quantity != 10 || pool.A != 1000e9 {
+		t.Error("Compare modified the book or the pool")
+	}
+}
+
+func TestCompareLatencyPenaltyFlipsWinner(t *testing.T) {
+	// Selling 1 base: the pool pays about 20.03 against the book's 19.99
+	market, book, pool := sampleMarket(), sampleBook(), newPool(1000, 20_150)
+	c, err := Compare(1, true, market, book, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Winner != Lifinity {
+		t.Fatalf("winner without penalties = %v, want lifinity", c.Winner)
+	}
+
+	// A 50 bps penalty on the slower pool hands the win to the book
+	pool.LatencyPenaltyBps = 50
+	c, err = Compare(1, true, market, book, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Winner != Phoenix {
+		t.Errorf("winner with the pool penalized = %v, want phoenix", c.Winner)
+	}
+	if c.Lifinity.OutAmount <= c.Phoenix.OutAmount {
+		t.Errorf("raw outputs = %v, %v; want the pool still ahead", c.Phoenix.OutAmount, c.Lifinity.OutAmount)
+	}
+	want := (c.Phoenix.AdjustedPrice - c.Lifinity.AdjustedPrice) / c.Lifinity.AdjustedPrice * 10_000
+	if math.Abs(c.MarginBps-want) > 1e-9 {
+		t.Errorf("margin = %v bps, want %v", c.MarginBps, want)
+	}
+}
+
+func TestCompareLatencyPenaltyOnBuys(t *testing.T) {
+	// Buying base with 25 quote: the pool at 22.5 beats the book's 25 ask
+	// until a heavy penalty pushes its adjusted price above the book's
+	market, book, pool := sampleMarket(), sampleBook(), newPool(1000, 22_500)
+	c, err := Compare(25, false, market, book, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Winner != Lifinity {
+		t.Fatalf("winner without penalties = %v, want lifinity", c.Winner)
+	}
+
+	pool.LatencyPenaltyBps = 1_500
+	if c, err = Compare(25, false, market, book, pool); err != nil {
+		t.Fatal(err)
+	}
+	if c.Winner != Phoenix {
+		t.Errorf("winner with the pool penalized = %v, want phoenix", c.Winner)
+	}
+}
+
+func TestCompareOneFails(t *testing.T) {
+	// The book only bids for 17 base
+	c, err := Compare(100, true, sampleMarket(), sampleBook(), newPool(1000, 22_500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(c.Phoenix.Err, phoenix.ErrInsufficientLiquidity) {
+		t.Errorf("phoenix err = %v, want ErrInsufficientLiquidity", c.Phoenix.Err)
+	}
+	if c.Lifinity.Err != nil || c.Lifinity.OutAmount <= 0 {
+		t.Errorf("lifinity = %+v, want a quote", c.Lifinity)
+	}
+	if c.Winner != Lifinity || c.MarginBps != 0 {
+		t.Errorf("winner = %v, margin = %v; want lifinity, 0", c.Winner, c.MarginBps)
+	}
+}
+
+func TestCompareBothFail(t *testing.T) {
+	// An empty pool cannot pay out either
+	pool := newPool(1000, 22_500)
+	pool.B = 0
+	if _, err := Compare(100, true, sampleMarket(), sampleBook(), pool); err == nil {
+		t.Fatal("expected an error when neither venue can quote")
+	}
+}
+
+func TestGeomeanPrice(t *testing.T) {
+	// Mid 22.5 and spot 40 give sqrt(900) = 30
+	price, err := GeomeanPrice(sampleBook(), newPool(1000, 40_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(price-30) > 1e-9 {
+		t.Errorf("geomean = %v, want 30", price)
+	}
+
+	// The same pool in raw units without decimals has the same price
+	raw := lifinity.NewLifinityLiquidity(1000, 40_000)
+	if price, err := GeomeanPrice(sampleBook(), raw); err != nil || math.Abs(price-30) > 1e-9 {
+		t.Errorf("geomean without decimals = %v, %v; want 30", price, err)
+	}
+}
+
+func TestGeomeanPriceUnavailable(t *testing.T) {
+	oneSided := sampleBook()
+	oneSided.Bids = nil
+	if _, err := GeomeanPrice(oneSided, newPool(1000, 40_000)); err == nil {
+		t.Error("expected an error for a book without bids")
+	}
+	if _, err := GeomeanPrice(sampleBook(), lifinity.NewLifinityLiquidity(0, 40_000)); err == nil {
+		t.Error("expected an error for an empty pool")
+	}
+}
+
+func TestCrossVenueSpreadBpsArbitrage(t *testing.T) {
+	// Asks start at 25 while the pool prices base at 30
+	book, pool := sampleBook(), newPool(1000, 30_000)
+	edge, err := CrossVenueSpreadBps(1, sampleMarket(), book, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cost := 25 * (1 + 5.0/10_000)
+	proceeds := 30_000 * 0.995 / 1000.995
+	want := (proceeds - cost) / cost * 10_000
+	if math.Abs(edge-want) > 1 {
+		t.Errorf("edge = %v bps, want about %v", edge, want)
+	}
+	if book.Asks[0].Quantity != 10 || pool.A != 1000e9 {
+		t.Error("CrossVenueSpreadBps modified the book or the pool")
+	}
+}
+
+func TestCrossVenueSpreadBpsNoArbitrage(t *testing.T) {
+	// The pool's 22.5 sits inside the book's 20/25 spread
+	edge, err := CrossVenueSpreadBps(1, sampleMarket(), sampleBook(), newPool(1000, 22_500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edge >= 0 {
+		t.Errorf("edge = %v bps, want negative", edge)
+	}
+}
+
+func TestOptimalSplitBeatsEitherVenue(t *testing.T) {
+	// Selling 15 base: the book pays 20 for the first 10 and 15 after that,
+	// while the pool starts just under 20 and degrades smoothly
+	market, book, pool := sampleMarket(), sampleBook(), newPool(1000, 20_000)
+	bookQuote, poolQuote, err := OptimalSplit(15, true, market, book, pool, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bookQuote == nil || poolQuote == nil {
+		t.Fatalf("quotes = %+v, %+v; want both venues used", bookQuote, poolQuote)
+	}
+	if in := bookQuote.InAmount + fromRaw(poolQuote.InAmount, 9); math.Abs(in-15) > 1e-9 {
+		t.Errorf("split spends %v, want 15", in)
+	}
+	split := bookQuote.OutAmount + fromRaw(poolQuote.OutAmount, 6)
+
+	bookOnly := quotePhoenix(15, true, market, book)
+	poolOnly := quoteLifinity(15, true, pool)
+	if bookOnly.Err != nil || poolOnly.Err != nil {
+		t.Fatal(bookOnly.Err, poolOnly.Err)
+	}
+	if split <= bookOnly.OutAmount || split <= poolOnly.OutAmount {
+		t.Errorf("split = %v, book alone = %v, pool alone = %v; want the split ahead", split, bookOnly.OutAmount, poolOnly.OutAmount)
+	}
+	if book.Bids[0].Quantity != 10 || pool.A != 1000e9 {
+		t.Error("OptimalSplit modified the book or the pool")
+	}
+}
+
+func TestOptimalSplitSingleVenue(t *testing.T) {
+	// The pool's marginal price stays above the book's only bid of 10 for the
+	// whole order, so the pool takes everything
+	book := sampleBook()
+	book.Bids = []phoenix.UiLadderLevel{{Price: 10, Quantity: 1000}}
+	bookQuote, poolQuote, err := OptimalSplit(100, true, sampleMarket(), book, newPool(1000, 20_000), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bookQuote != nil || poolQuote == nil || poolQuote.InAmount != 100e9 {
+		t.Errorf("quotes = %+v, %+v; want the whole amount on the pool", bookQuote, poolQuote)
+	}
+}
+
+func TestFindArbitrage(t *testing.T) {
+	// Base costs 25 on the book and sells for about 30 to the pool
+	book, pool := sampleBook(), newPool(1000, 30_000)
+	arb, err := FindArbitrage(sampleMarket(), book, pool, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arb == nil {
+		t.Fatal("expected an arbitrage")
+	}
+	if arb.BuyOn != Phoenix || arb.SellOn != Lifinity {
+		t.Errorf("buy on %v, sell on %v; want phoenix then lifinity", arb.BuyOn, arb.SellOn)
+	}
+	// The pool still pays more than 25 after 10 base, so the largest size wins
+	if math.Abs(arb.Size-10) > 1e-9 {
+		t.Errorf("size = %v, want 10", arb.Size)
+	}
+	if math.Abs(arb.Cost-250*(1+5.0/10_000)) > 1e-6 {
+		t.Errorf("cost = %v, want 250.125", arb.Cost)
+	}
+	if math.Abs(arb.Profit-(arb.Proceeds-arb.Cost)) > 1e-9 || arb.Profit <= 0 {
+		t.Errorf("profit = %v, proceeds = %v, cost = %v", arb.Profit, arb.Proceeds, arb.Cost)
+	}
+	if book.Asks[0].Quantity != 10 || pool.A != 1000e9 {
+		t.Error("FindArbitrage modified the book or the pool")
+	}
+}
+
+func TestFindArbitrageNone(t *testing.T) {
+	arb, err := FindArbitrage(sampleMarket(), sampleBook(), newPool(1000, 22_500), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arb != nil {
+		t.Errorf("arbitrage = %+v, want none", arb)
+	}
+}
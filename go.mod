@@ -0,0 +1,3 @@
+module github.com/marccanlas/phoenix-sdk-migration
+
+go 1.21
@@ -0,0 +1,1146 @@
+package lifinity
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marccanlas/phoenix-sdk-migration/venue"
+)
+
+type LifinityLiquidity struct {
+	A                 uint64   // Reserve for base token (e.g., SOL)
+	B                 uint64   // Reserve for quote token (e.g., USDC)
+	Rounding          Rounding // How the exact curve output is rounded to whole units
+	MinReserveA       uint64   // Post-swap floor for A; swaps breaching it are rejected
+	MinReserveB       uint64   // Post-swap floor for B; swaps breaching it are rejected
+	ReadOnly          bool     // Never change reserves: GetQuote only simulates and ApplyTrade fails
+	MinNotional       uint64   // Smallest input, valued in B at spot, a swap accepts; zero disables the check
+	FeeBps            uint     // Pool fee in basis points of the input; LifinityFeeRate by default
+	LatencyPenaltyBps float64  // Execution-risk haircut in bps of price; see LatencyAdjustedPrice
+	// Decimals of the A and B tokens. Reserves and quotes are always in raw
+	// units; these only scale the venue.Amount values Quote takes and returns.
+	DecimalsA, DecimalsB int
+
+	impactHalfLife float64         // Seconds for price impact to halve; see ImpactDecay
+	history        *reserveHistory // Recent reserves for TwapQuote; see RecordReserves
+}
+
+// Rounding selects how the exact curve output, the output reserve minus K over
+// the new input reserve, is rounded to whole units. The post-swap output reserve
+// is whatever is left after paying the rounded output, so the stored reserves
+// always match what was paid.
+type Rounding int
+
+const (
+	// RoundDown floors the output, always against the trader, and keeps
+	// A*B >= K. This is the default and the only policy that is safe for
+	// execution.
+	RoundDown Rounding = iota
+	// RoundUp ceils the output in the trader's favor. The pool can then pay out
+	// up to a unit more than the invariant allows, so use it for display only.
+	RoundUp
+	// RoundHalfEven rounds to the nearest unit, ties to even, so rounding does
+	// not bias either side on average.
+	RoundHalfEven
+)
+
+// fraction classifies the part of the exact post-swap reserve below one unit.
+type fraction int
+
+const (
+	fractionZero fraction = iota
+	fractionBelowHalf
+	fractionHalf
+	fractionAboveHalf
+)
+
+// reserveQuotient is the exact post-swap output reserve K/x, as its whole part
+// and the class of what is left over.
+type reserveQuotient struct {
+	whole uint64
+	frac  fraction
+}
+
+// fractionOf classifies remainder/denom.
+func fractionOf(remainder, denom uint64) fraction {
+	switch {
+	case remainder == 0:
+		return fractionZero
+	case remainder < denom-remainder:
+		return fractionBelowHalf
+	case remainder == denom-remainder:
+		return fractionHalf
+	}
+	return fractionAboveHalf
+}
+
+// output rounds reserveOut minus the exact reserve q to whole units. The exact
+// output's fraction is the complement of q's, so ceiling it is reserveOut minus
+// q's whole part. q.whole must be below reserveOut.
+func (r Rounding) output(reserveOut uint64, q reserveQuotient) uint64 {
+	ceil := reserveOut - q.whole
+	if q.frac == fractionZero || r == RoundUp {
+		return ceil
+	}
+	if r == RoundHalfEven {
+		switch q.frac {
+		case fractionBelowHalf:
+			// The output's own fraction is above one half
+			return ceil
+		case fractionHalf:
+			if ceil%2 == 0 {
+				return ceil
+			}
+		}
+	}
+	return ceil - 1
+}
+
+func NewLifinityLiquidity(a, b uint64) *LifinityLiquidity {
+	return NewLifinityLiquidityWithFee(a, b, LifinityFeeRate)
+}
+
+// NewLifinityLiquidityWithFee is NewLifinityLiquidity for a pool on a fee tier
+// other than LifinityFeeRate.
+func NewLifinityLiquidityWithFee(a, b uint64, feeBps uint) *LifinityLiquidity {
+	return &LifinityLiquidity{
+		A:      a,
+		B:      b,
+		FeeBps: feeBps,
+	}
+}
+
+func (l *LifinityLiquidity) Price(aToB bool) float64 {
+	if aToB {
+		return float64(l.A) / float64(l.B)
+	} else {
+		return float64(l.B) / float64(l.A)
+	}
+}
+
+// Prices returns the spot price in both conventions: baseInQuote is how much
+// quote one base token is worth (B/A), quoteInBase its reciprocal (A/B).
+func (l *LifinityLiquidity) Prices() (baseInQuote float64, quoteInBase float64) {
+	return float64(l.B) / float64(l.A), float64(l.A) / float64(l.B)
+}
+
+// K returns the constant product (x * y = k) of the current reserves. It is
+// computed on every call, so it follows the reserves as swaps move them.
+func (l *LifinityLiquidity) K() float64 {
+	return float64(l.A) * float64(l.B)
+}
+
+// MaxOutput returns the most a single swap can ever pay out in the given
+// direction. The curve is asymptotic, so the output reserve is never drained.
+func (l *LifinityLiquidity) MaxOutput(aToB bool) uint64 {
+	outputReserve := l.A
+	if aToB {
+		outputReserve = l.B
+	}
+	if outputReserve == 0 {
+		return 0
+	}
+	return outputReserve - 1
+}
+
+const (
+	LifinityFeeRate = 50 // Default FeeBps, e.g., 50 BPS = 0.5%
+)
+
+// RoundTripFeeDragBps returns the share of size, in basis points, lost to
+// FeeBps when entering and then exiting a position. Each leg's fee is
+// rounded up like in GetQuote, so small sizes pay proportionally more.
+func (l *LifinityLiquidity) RoundTripFeeDragBps(size uint64) float64 {
+	if size == 0 {
+		return 0
+	}
+	entryFee := l.feeOn(size)
+	exitFee := l.feeOn(size - entryFee)
+	return float64(entryFee+exitFee) / float64(size) * 10_000
+}
+
+// LiquidityInBand returns how much of the quote token (B) trades before the
+// spot price (B/A) moves by bps in each direction: buyable is the quote input,
+// fee included, that pushes the price up by bps, and sellable is the quote paid
+// out to sellers of base as the price falls by bps. The pool is not modified.
+func (l *LifinityLiquidity) LiquidityInBand(bps float64) (buyable, sellable uint64) {
+	if bps <= 0 || bps >= 10_000 || l.A == 0 || l.B == 0 {
+		return 0, 0
+	}
+
+	price := float64(l.B) / float64(l.A)
+	feeFactor := l.gamma()
+
+	upperB := math.Sqrt(l.K() * price * (1 + bps/10_000))
+	lowerB := math.Sqrt(l.K() * price * (1 - bps/10_000))
+
+	if upperB > float64(l.B) {
+		buyable = uint64((upperB - float64(l.B)) / feeFactor)
+	}
+	if lowerB < float64(l.B) {
+		sellable = uint64(float64(l.B) - lowerB)
+	}
+	return buyable, sellable
+}
+
+// feeOn returns the pool fee on amount input units, rounded up so any positive
+// input at a positive FeeBps pays at least one unit and splitting an order
+// never saves fees. It is computed in 128 bits so large amounts cannot overflow.
+func (l *LifinityLiquidity) feeOn(amount uint64) uint64 {
+	hi, lo := bits.Mul64(amount, uint64(l.FeeBps))
+	fee, remainder := bits.Div64(hi, lo, 10_000)
+	if remainder > 0 {
+		fee++
+	}
+	return fee
+}
+
+// gamma is the share of each input unit that reaches the reserves after FeeBps.
+func (l *LifinityLiquidity) gamma() float64 {
+	return 1 - float64(l.FeeBps)/10_000
+}
+
+// MarginalPriceAfter returns the marginal cost, in input units per unit of
+// output, of the next infinitesimal trade after inAmount has been swapped:
+// (reserveIn + γ*inAmount)² / (γ*K), where γ is one minus the fee rate. It is
+// zero for an empty pool. The pool is not modified.
+func (l *LifinityLiquidity) MarginalPriceAfter(inAmount uint64, aToB bool) float64 {
+	reserveIn := l.B
+	if aToB {
+		reserveIn = l.A
+	}
+	if reserveIn == 0 || l.K() == 0 {
+		return 0
+	}
+
+	gamma := l.gamma()
+	effectiveIn := float64(reserveIn) + gamma*float64(inAmount)
+	return effectiveIn * effectiveIn / (gamma * l.K())
+}
+
+// PriceForMarginalOutput returns the spot price, as base in quote (B/A), at
+// which the next unit of input would yield outPerUnit of output. It inverts
+// MarginalPriceAfter: at the state reached after any inAmount,
+// PriceForMarginalOutput(1/MarginalPriceAfter(inAmount, aToB), aToB) is that
+// state's spot price.
+func (l *LifinityLiquidity) PriceForMarginalOutput(outPerUnit float64, aToB bool) float64 {
+	if outPerUnit <= 0 {
+		return 0
+	}
+
+	gamma := l.gamma()
+	if aToB {
+		// Selling base yields gamma * B/A quote per unit
+		return outPerUnit / gamma
+	}
+	// Buying base yields gamma * A/B base per unit of quote
+	return gamma / outPerUnit
+}
+
+// BalancingSwap returns the swap that moves the spot price, as base in quote
+// (B/A), to targetPrice along the constant-product curve. inAmount includes the
+// pool fee, so swapping it leaves the input reserve at sqrt(K*targetPrice) or
+// sqrt(K/targetPrice). It is zero when the pool is already at the target.
+func (l *LifinityLiquidity) BalancingSwap(targetPrice float64) (inAmount uint64, aToB bool, err error) {
+	if !(targetPrice > 0) || math.IsInf(targetPrice, 0) {
+		return 0, false, fmt.Errorf("invalid target price %v", targetPrice)
+	}
+	if l.A == 0 || l.B == 0 {
+		return 0, false, errors.New("pool has an empty reserve")
+	}
+
+	// Selling A lowers B/A; buying A with B raises it
+	aToB = targetPrice < float64(l.B)/float64(l.A)
+	reserveIn, targetReserve := float64(l.B), math.Sqrt(l.K()*targetPrice)
+	if aToB {
+		reserveIn, targetReserve = float64(l.A), math.Sqrt(l.K()/targetPrice)
+	}
+	if targetReserve <= reserveIn {
+		return 0, aToB, nil
+	}
+
+	gamma := l.gamma()
+	gross := math.Ceil((targetReserve - reserveIn) / gamma)
+	if gross >= math.MaxUint64 {
+		return 0, false, fmt.Errorf("target price %v is out of range", targetPrice)
+	}
+	return uint64(gross), aToB, nil
+}
+
+// MarginalCostCurve samples MarginalPriceAfter at steps evenly spaced input
+// sizes up to maxIn, for overlaying against an order book's level costs. The
+// pool is not modified.
+func (l *LifinityLiquidity) MarginalCostCurve(maxIn uint64, steps int, aToB bool) ([]float64, error) {
+	if maxIn == 0 || steps <= 0 {
+		return nil, errors.New("maxIn and steps must be greater than zero")
+	}
+	if l.A == 0 || l.B == 0 {
+		return nil, errors.New("pool has an empty reserve")
+	}
+
+	curve := make([]float64, steps)
+	for i := range curve {
+		inAmount := uint64(float64(maxIn) * float64(i+1) / float64(steps))
+		curve[i] = l.MarginalPriceAfter(inAmount, aToB)
+	}
+	return curve, nil
+}
+
+// ProjectedFeeRevenue estimates the fees earned on dailyVolume input units at
+// FeeBps. It is a linear approximation: it ignores how the volume moves the
+// reserves and the per-swap fee rounding in GetQuote.
+func (l *LifinityLiquidity) ProjectedFeeRevenue(dailyVolume uint64) uint64 {
+	// Split the product so large volumes cannot overflow
+	feeBps := uint64(l.FeeBps)
+	return dailyVolume/10_000*feeBps + dailyVolume%10_000*feeBps/10_000
+}
+
+// BreakEvenFeeBps returns the fee rate, in bps, at which fee income offsets
+// the pool's loss-versus-rebalancing (LVR) over one period, given the realized
+// volatility of the B/A price over that period, in bps, and the volume the pool
+// trades in it, in quote (B) units.
+//
+// A constant-product pool loses σ²/8 of its value per unit of time to LVR,
+// where σ² is the variance rate of the price (Milionis, Moallemi, Roughgarden
+// and Zhang, "Automated Market Making and Loss-Versus-Rebalancing", 2022). It
+// holds equal value in both tokens, 2B in quote terms, so over a period with
+// volatility σ it loses σ²·2B/8 while earning fee·volume. Equating the two:
+//
+//	fee = σ²·B / (4·volume)
+//
+// The volume is taken as given: it is assumed not to change with the fee, and
+// fees are treated as linear in it, as in ProjectedFeeRevenue.
+func (l *LifinityLiquidity) BreakEvenFeeBps(realizedVolBps float64, periodVolume uint64) (float64, error) {
+	if realizedVolBps < 0 {
+		return 0, fmt.Errorf("invalid volatility %v bps", realizedVolBps)
+	}
+	if periodVolume == 0 {
+		return 0, errors.New("no volume to earn fees on")
+	}
+	if l.B == 0 {
+		return 0, errors.New("pool has an empty reserve")
+	}
+	sigma := realizedVolBps / 10_000
+	return sigma * sigma * float64(l.B) / (4 * float64(periodVolume)) * 10_000, nil
+}
+
+// ImpactDecay sets how quickly price impact fades after a trade, as the time in
+// seconds for it to halve. Zero or negative disables decay.
+func (l *LifinityLiquidity) ImpactDecay(halfLifeSeconds float64) {
+	l.impactHalfLife = halfLifeSeconds
+}
+
+// ImpactAfter returns how much of initialBps of impact remains after
+// elapsedSeconds, assuming exponential decay with the ImpactDecay half-life.
+// This is a modeling aid; reserves are not touched.
+func (l *LifinityLiquidity) ImpactAfter(initialBps float64, elapsedSeconds float64) float64 {
+	if l.impactHalfLife <= 0 || elapsedSeconds <= 0 {
+		return initialBps
+	}
+	return initialBps * math.Exp2(-elapsedSeconds/l.impactHalfLife)
+}
+
+// reserveHistorySize is how many observations RecordReserves keeps.
+const reserveHistorySize = 64
+
+type reserveObservation struct {
+	a, b uint64
+	at   time.Time
+}
+
+// reserveHistory is a ring buffer of reserve observations in time order.
+type reserveHistory struct {
+	observations [reserveHistorySize]reserveObservation
+	next         int
+	count        int
+}
+
+// at returns the i-th oldest observation.
+func (h *reserveHistory) at(i int) reserveObservation {
+	return h.observations[(h.next-h.count+i+reserveHistorySize)%reserveHistorySize]
+}
+
+// RecordReserves records the current reserves for TwapQuote. It fails like
+// RecordReservesAt if the clock has moved back past the previous observation.
+func (l *LifinityLiquidity) RecordReserves() error {
+	return l.RecordReservesAt(time.Now())
+}
+
+// RecordReservesAt records the current reserves as observed at t, which must not
+// be earlier than the previous observation. Only the most recent
+// reserveHistorySize observations are kept.
+func (l *LifinityLiquidity) RecordReservesAt(t time.Time) error {
+	if l.history == nil {
+		l.history = &reserveHistory{}
+	}
+	h := l.history
+	if h.count > 0 && t.Before(h.at(h.count-1).at) {
+		return errors.New("reserve observations must be recorded in time order")
+	}
+
+	h.observations[h.next] = reserveObservation{a: l.A, b: l.B, at: t}
+	h.next = (h.next + 1) % reserveHistorySize
+	if h.count < reserveHistorySize {
+		h.count++
+	}
+	return nil
+}
+
+// TwapQuote prices a swap, without executing it, against the time-weighted
+// average of the reserves recorded over the last windowSeconds.
+//
+// Sampling model: each recorded observation is taken to hold from its
+// timestamp until the next one, and the latest until now. Only the part of the
+// window covered by observations is averaged; if that part has no duration, the
+// latest observation is used as is.
+func (l *LifinityLiquidity) TwapQuote(params QuoteParams, windowSeconds float64) (*Quote, error) {
+	a, b, err := l.twapReserves(windowSeconds, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	pool := *l
+	pool.A, pool.B = a, b
+	return pool.SimulateQuote(params)
+}
+
+// OutputRange prices a swap, without executing it, against reserves that may be
+// off by up to reserveUncertaintyPct percent. Both reserves are scaled together,
+// so the spot price is kept and only depth varies: min is the output with the
+// reserves shrunk, max with them grown, and mid with them as recorded.
+func (l *LifinityLiquidity) OutputRange(inAmount uint64, aToB bool, reserveUncertaintyPct float64) (min, max, mid uint64, err error) {
+	if reserveUncertaintyPct < 0 || reserveUncertaintyPct >= 100 {
+		return 0, 0, 0, fmt.Errorf("reserve uncertainty %v%% must be in [0, 100)", reserveUncertaintyPct)
+	}
+
+	params := QuoteParams{InAmount: inAmount, AToB: aToB}
+	outputs := make([]uint64, 3)
+	for i, scale := range []float64{1 - reserveUncertaintyPct/100, 1, 1 + reserveUncertaintyPct/100} {
+		pool := *l
+		pool.A = uint64(float64(l.A) * scale)
+		pool.B = uint64(float64(l.B) * scale)
+
+		quote, err := pool.SimulateQuote(params)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		outputs[i] = quote.OutAmount
+	}
+	return outputs[0], outputs[2], outputs[1], nil
+}
+
+func (l *LifinityLiquidity) twapReserves(windowSeconds float64, now time.Time) (uint64, uint64, error) {
+	if windowSeconds <= 0 {
+		return 0, 0, errors.New("TWAP window must be greater than zero")
+	}
+	h := l.history
+	if h == nil || h.count == 0 {
+		return 0, 0, errors.New("no reserve observations recorded")
+	}
+
+	windowStart := now.Add(-time.Duration(windowSeconds * float64(time.Second)))
+	var sumA, sumB, total float64
+	for i := 0; i < h.count; i++ {
+		observation := h.at(i)
+		start, end := observation.at, now
+		if i+1 < h.count {
+			end = h.at(i + 1).at
+		}
+		if start.Before(windowStart) {
+			start = windowStart
+		}
+		if end.After(now) {
+			end = now
+		}
+
+		weight := end.Sub(start).Seconds()
+		if weight <= 0 {
+			continue
+		}
+		sumA += float64(observation.a) * weight
+		sumB += float64(observation.b) * weight
+		total += weight
+	}
+
+	if total == 0 {
+		latest := h.at(h.count - 1)
+		return latest.a, latest.b, nil
+	}
+	return uint64(math.Round(sumA / total)), uint64(math.Round(sumB / total)), nil
+}
+
+type QuoteParams struct {
+	InAmount       uint64 // Input token amount for the swap
+	AToB           bool   // Direction: true for base to quote (A -> B), false for quote to base (B -> A)
+	AbsoluteMinOut uint64 // Reject quotes paying out less than this; zero disables the floor
+	Pool           string // Pool the trade is routed to in a basket; ignored by single-pool quotes
+	// Integrator fee per trade in quote (B) units, on top of the pool fee: taken
+	// from the output of A-to-B swaps and from the input of B-to-A swaps
+	FlatFee uint64
+	// Slippage bound on OutAmount, checked after AbsoluteMinOut and reported as a
+	// *SlippageError; zero means no limit
+	MinOutAmount uint64
+}
+
+// ErrBelowFloor is returned when a quote pays out less than QuoteParams.AbsoluteMinOut.
+var ErrBelowFloor = errors.New("quote output is below the absolute minimum")
+
+// ErrSlippageExceeded matches, via errors.Is, the *SlippageError returned when
+// a quote pays out less than QuoteParams.MinOutAmount.
+var ErrSlippageExceeded = errors.New("slippage exceeded")
+
+// SlippageError carries the output a quote computed and the minimum the caller
+// required.
+type SlippageError struct {
+	OutAmount    uint64
+	MinOutAmount uint64
+}
+
+func (e *SlippageError) Error() string {
+	return fmt.Sprintf("%v: got %d, minimum %d", ErrSlippageExceeded, e.OutAmount, e.MinOutAmount)
+}
+
+func (e *SlippageError) Unwrap() error {
+	return ErrSlippageExceeded
+}
+
+// ErrBelowMinNotional is returned when a swap's input is worth less than
+// MinNotional in quote (B) terms.
+var ErrBelowMinNotional = errors.New("order notional is below the minimum")
+
+// ErrInsufficientLiquidity is returned when the pool cannot pay out the
+// requested amount at all, such as an exact output that would drain a reserve.
+var ErrInsufficientLiquidity = errors.New("not enough liquidity to fulfill the trade")
+
+// ErrInsufficientOutput is returned when a swap is too small for the pool to
+// pay out a whole unit of the output token.
+var ErrInsufficientOutput = errors.New("insufficient output")
+
+// ErrReadOnly is returned when trying to execute against a ReadOnly pool.
+var ErrReadOnly = errors.New("pool is read-only")
+
+// ErrReserveFloorBreached is returned when a swap would leave a reserve below
+// MinReserveA or MinReserveB.
+var ErrReserveFloorBreached = errors.New("swap would breach a reserve floor")
+
+type Quote struct {
+	InAmount       uint64 `json:"inAmount"`       // Amount of input tokens
+	OutAmount      uint64 `json:"outAmount"`      // Amount of output tokens
+	PriceImpactBP  uint   `json:"priceImpactBp"`  // Price impact in basis points
+	FeeAmount      uint64 `json:"feeAmount"`      // Part of InAmount kept as the pool fee
+	FlatFee        uint64 `json:"flatFee"`        // Flat integrator fee in B units, included in InAmount or deducted from OutAmount
+	AToB           bool   `json:"aToB"`           // Direction of the swap that produced the quote
+	ReserveLimited bool   `json:"reserveLimited"` // OutAmount is capped at the output reserve minus one, the most the pool pays
+}
+
+// Format renders InAmount and OutAmount, which are raw token units, as plain
+// decimal strings scaled by their token's decimals, without trailing zeros.
+// A-to-B swaps spend base and receive quote; B-to-A swaps the reverse.
+func (q *Quote) Format(baseDecimals, quoteDecimals int) (inStr, outStr string) {
+	if q.AToB {
+		return formatUnits(q.InAmount, baseDecimals), formatUnits(q.OutAmount, quoteDecimals)
+	}
+	return formatUnits(q.InAmount, quoteDecimals), formatUnits(q.OutAmount, baseDecimals)
+}
+
+func formatUnits(v uint64, decimals int) string {
+	digits := strconv.FormatUint(v, 10)
+	if decimals <= 0 {
+		return digits
+	}
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	whole, frac := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+// AverageFillPrice returns the quote's effective price in quote per base (B
+// per A), fees included: OutAmount/InAmount for A-to-B swaps and
+// InAmount/OutAmount for B-to-A swaps.
+func (q *Quote) AverageFillPrice() (float64, error) {
+	if q.InAmount == 0 || q.OutAmount == 0 {
+		return 0, fmt.Errorf("no fill to price: in %d, out %d", q.InAmount, q.OutAmount)
+	}
+	if q.AToB {
+		return float64(q.OutAmount) / float64(q.InAmount), nil
+	}
+	return float64(q.InAmount) / float64(q.OutAmount), nil
+}
+
+// Validate checks that the quote is internally consistent before acting on it.
+// PriceImpactBP is not bounded: a swap as large as the input reserve moves the
+// price by well over 100% in either direction.
+func (q *Quote) Validate() error {
+	if q.InAmount == 0 {
+		return errors.New("quote has zero input")
+	}
+	if q.OutAmount == 0 {
+		return errors.New("quote has zero output")
+	}
+	if q.FeeAmount > q.InAmount {
+		return fmt.Errorf("fee %d exceeds input %d", q.FeeAmount, q.InAmount)
+	}
+	return nil
+}
+
+// Hash returns a stable FNV-1a digest of every field of the quote. All fields
+// are integers, so no rounding is needed.
+func (q *Quote) Hash() string {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, v := range []uint64{q.InAmount, q.OutAmount, uint64(q.PriceImpactBP), q.FeeAmount, q.FlatFee} {
+		binary.BigEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	h.Write([]byte{boolByte(q.AToB), boolByte(q.ReserveLimited)})
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// swapResult is the outcome of a swap priced against the current reserves.
+type swapResult struct {
+	afterA, afterB uint64
+	outAmount      uint64
+	feeAmount      uint64
+	reserveLimited bool
+}
+
+// swap prices a swap without touching the pool's reserves. precise computes
+// the post-swap output reserve in math/big from the live reserves instead of
+// dividing the float64 K; see GetQuotePrecise.
+func (l *LifinityLiquidity) swap(params QuoteParams, precise bool) (*swapResult, error) {
+	if l.MinNotional > 0 {
+		// B-to-A input is already quote; A-to-B input is valued at spot
+		notional := float64(params.InAmount)
+		if params.AToB {
+			notional = notional * float64(l.B) / float64(l.A)
+		}
+		if notional < float64(l.MinNotional) {
+			return nil, fmt.Errorf("%w: %v < %d", ErrBelowMinNotional, notional, l.MinNotional)
+		}
+	}
+
+	// A B-to-A swap pays the flat fee before the pool sees the input
+	swapIn := params.InAmount
+	if !params.AToB && params.FlatFee > 0 {
+		if params.FlatFee >= swapIn {
+			return nil, fmt.Errorf("flat fee %d exceeds the input amount", params.FlatFee)
+		}
+		swapIn -= params.FlatFee
+	}
+
+	if l.FeeBps >= 10_000 {
+		return nil, fmt.Errorf("fee of %d bps takes the whole input", l.FeeBps)
+	}
+	feeAmount := l.feeOn(swapIn)
+
+	var outputReserve uint64
+	var afterA, afterB uint64
+	var afterOutput reserveQuotient
+
+	if params.AToB {
+		// A to B swap (Base -> Quote)
+		afterA = l.A + swapIn - feeAmount
+		if precise && afterA < l.A {
+			return nil, fmt.Errorf("input reserve would overflow: %d + %d", l.A, swapIn-feeAmount)
+		}
+		afterOutput = l.reserveAfter(afterA, precise) // Calculate B based on new A
+		outputReserve = l.B
+	} else {
+		// B to A swap (Quote -> Base)
+		afterB = l.B + swapIn - feeAmount
+		if precise && afterB < l.B {
+			return nil, fmt.Errorf("input reserve would overflow: %d + %d", l.B, swapIn-feeAmount)
+		}
+		afterOutput = l.reserveAfter(afterB, precise) // Calculate A based on new B
+		outputReserve = l.A
+	}
+
+	// The output can never meet or exceed the reserve it is paid from
+	if outputReserve == 0 {
+		return nil, fmt.Errorf("%w: output reserve is zero", ErrInsufficientLiquidity)
+	}
+	// Subtracting here would wrap around
+	if afterOutput.whole >= outputReserve {
+		return nil, fmt.Errorf("%w: reserve %d would not decrease", ErrInsufficientOutput, outputReserve)
+	}
+	outAmount := l.Rounding.output(outputReserve, afterOutput)
+	if outAmount == 0 {
+		return nil, fmt.Errorf("%w: input %d buys nothing", ErrInsufficientOutput, params.InAmount)
+	}
+	// The pool keeps at least one unit of the output token, whichever way the
+	// output was rounded
+	reserveLimited := outAmount >= outputReserve-1
+	outAmount = min(outAmount, outputReserve-1)
+	if params.AToB {
+		afterB = outputReserve - outAmount
+	} else {
+		afterA = outputReserve - outAmount
+	}
+
+	if afterA == 0 || afterB == 0 {
+		return nil, fmt.Errorf("afterLiquidity is zero")
+	}
+
+	if afterA < l.MinReserveA || afterB < l.MinReserveB {
+		return nil, fmt.Errorf("%w: reserves would be A=%d, B=%d", ErrReserveFloorBreached, afterA, afterB)
+	}
+
+	// The pool pays out in full; an A-to-B flat fee comes out of what the trader receives
+	if params.AToB && params.FlatFee > 0 {
+		if params.FlatFee >= outAmount {
+			return nil, fmt.Errorf("flat fee %d exceeds the output amount", params.FlatFee)
+		}
+		outAmount -= params.FlatFee
+	}
+
+	if outAmount < params.AbsoluteMinOut {
+		return nil, fmt.Errorf("%w: got %d, floor %d", ErrBelowFloor, outAmount, params.AbsoluteMinOut)
+	}
+	if outAmount < params.MinOutAmount {
+		return nil, &SlippageError{OutAmount: outAmount, MinOutAmount: params.MinOutAmount}
+	}
+
+	return &swapResult{
+		afterA:         afterA,
+		afterB:         afterB,
+		outAmount:      outAmount,
+		feeAmount:      feeAmount,
+		reserveLimited: reserveLimited,
+	}, nil
+}
+
+// GetQuote executes a swap: it prices params against the reserves and then
+// moves them to the post-swap state. A ReadOnly pool is only simulated.
+func (l *LifinityLiquidity) GetQuote(params QuoteParams) (*Quote, error) {
+	return l.execute(params, false)
+}
+
+// GetQuoteContext is GetQuote for callers fanning out over many venues: it
+// returns ctx.Err() without touching the pool once ctx is done. Pricing itself
+// is constant time, so ctx is only checked up front.
+func (l *LifinityLiquidity) GetQuoteContext(ctx context.Context, params QuoteParams) (*Quote, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return l.GetQuote(params)
+}
+
+// GetQuotePrecise is GetQuote with the constant product kept exact: K is the
+// product of the live reserves as a math/big integer and the post-swap output
+// reserve is an integer division, so quotes on pools whose reserves exceed 2^53
+// are not skewed by float64 rounding. Reserves may be any uint64 value.
+func (l *LifinityLiquidity) GetQuotePrecise(params QuoteParams) (*Quote, error) {
+	return l.execute(params, true)
+}
+
+// execute prices a swap and, unless the pool is ReadOnly, applies it.
+func (l *LifinityLiquidity) execute(params QuoteParams, precise bool) (*Quote, error) {
+	result, err := l.swap(params, precise)
+	if err != nil {
+		return nil, err
+	}
+	quote := l.quoteFor(params, result)
+	if !l.ReadOnly {
+		l.A = result.afterA
+		l.B = result.afterB
+	}
+	return quote, nil
+}
+
+// reserveAfter returns the output reserve that keeps the constant product once
+// the input reserve is afterIn, before the output is rounded.
+func (l *LifinityLiquidity) reserveAfter(afterIn uint64, precise bool) reserveQuotient {
+	if afterIn == 0 {
+		return reserveQuotient{}
+	}
+	if precise {
+		return bigMulDiv(l.A, l.B, afterIn)
+	}
+
+	exact := l.K() / float64(afterIn)
+	whole := math.Floor(exact)
+	q := reserveQuotient{whole: uint64(whole)}
+	switch rest := exact - whole; {
+	case rest == 0:
+		q.frac = fractionZero
+	case rest < 0.5:
+		q.frac = fractionBelowHalf
+	case rest == 0.5:
+		q.frac = fractionHalf
+	default:
+		q.frac = fractionAboveHalf
+	}
+	return q
+}
+
+// quoteFor builds the quote of a swap priced against the current reserves,
+// which are left untouched.
+func (l *LifinityLiquidity) quoteFor(params QuoteParams, result *swapResult) *Quote {
+	// Impact compares the spot price, in the swap's own convention, before and
+	// after the reserves move
+	after := LifinityLiquidity{A: result.afterA, B: result.afterB}
+	beforePrice := l.Price(params.AToB)
+	afterPrice := after.Price(params.AToB)
+	priceImpactBP := math.Abs(afterPrice-beforePrice) / beforePrice * 10_000
+
+	return &Quote{
+		InAmount:       params.InAmount,
+		OutAmount:      result.outAmount,
+		PriceImpactBP:  uint(priceImpactBP),
+		FeeAmount:      result.feeAmount,
+		FlatFee:        params.FlatFee,
+		AToB:           params.AToB,
+		ReserveLimited: result.reserveLimited,
+	}
+}
+
+// OutAmount128 returns what GetQuote would pay out for inAmount, computing the
+// post-swap reserve A*B/x in 128-bit integer arithmetic so it is exact for any
+// uint64 reserves without allocating. K is taken from the live reserves. The
+// pool fee and Rounding apply as in GetQuote; the reserve floors, MinNotional
+// and FlatFee do not.
+func (l *LifinityLiquidity) OutAmount128(inAmount uint64, aToB bool) (uint64, error) {
+	reserveIn, reserveOut := l.B, l.A
+	if aToB {
+		reserveIn, reserveOut = l.A, l.B
+	}
+	if reserveIn == 0 || reserveOut == 0 {
+		return 0, errors.New("pool has an empty reserve")
+	}
+
+	if l.FeeBps >= 10_000 {
+		return 0, fmt.Errorf("fee of %d bps takes the whole input", l.FeeBps)
+	}
+	feeAmount := l.feeOn(inAmount)
+
+	afterIn, carry := bits.Add64(reserveIn, inAmount-feeAmount, 0)
+	if carry != 0 {
+		return 0, fmt.Errorf("input reserve would overflow: %d + %d", reserveIn, inAmount-feeAmount)
+	}
+	afterOut := mulDiv128(reserveIn, reserveOut, afterIn)
+
+	if afterOut.whole >= reserveOut {
+		return 0, fmt.Errorf("%w: reserve %d would not decrease", ErrInsufficientOutput, reserveOut)
+	}
+	outAmount := l.Rounding.output(reserveOut, afterOut)
+	if outAmount == 0 {
+		return 0, fmt.Errorf("%w: input %d buys nothing", ErrInsufficientOutput, inAmount)
+	}
+	return min(outAmount, reserveOut-1), nil
+}
+
+// bigMulDiv returns a*b/denom, computed in math/big. denom must not be zero,
+// and the quotient must fit in 64 bits, which holds whenever denom >= a or
+// denom >= b.
+func bigMulDiv(a, b, denom uint64) reserveQuotient {
+	product := new(big.Int).Mul(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b))
+	quotient, remainder := new(big.Int).QuoRem(product, new(big.Int).SetUint64(denom), new(big.Int))
+	return reserveQuotient{whole: quotient.Uint64(), frac: fractionOf(remainder.Uint64(), denom)}
+}
+
+// mulDiv128 returns a*b/denom. The quotient must fit in 64 bits, which holds
+// whenever denom >= a.
+func mulDiv128(a, b, denom uint64) reserveQuotient {
+	hi, lo := bits.Mul64(a, b)
+	quotient, remainder := bits.Div64(hi, lo, denom)
+	return reserveQuotient{whole: quotient, frac: fractionOf(remainder, denom)}
+}
+
+// exactOutSearchLimit bounds how far GetQuoteExactOut steps its closed-form
+// estimate to absorb fee and reserve rounding.
+const exactOutSearchLimit = 64
+
+// GetQuoteExactOut executes, like GetQuote, the smallest swap that pays out at
+// least outAmount. The input is found by inverting the constant product,
+// K/(reserveOut-outAmount) - reserveIn, grossed up for FeeBps and then
+// stepped to the exact integer that the swap math accepts. OutAmount can exceed
+// outAmount by the rounding granularity.
+func (l *LifinityLiquidity) GetQuoteExactOut(outAmount uint64, aToB bool) (*Quote, error) {
+	reserveIn, reserveOut := l.B, l.A
+	if aToB {
+		reserveIn, reserveOut = l.A, l.B
+	}
+	if outAmount == 0 {
+		return nil, errors.New("output amount must be greater than zero")
+	}
+	if outAmount >= reserveOut {
+		return nil, fmt.Errorf("%w: output %d would drain the reserve of %d", ErrInsufficientLiquidity, outAmount, reserveOut)
+	}
+
+	afterIn := math.Ceil(l.K() / float64(reserveOut-outAmount))
+	netIn := math.Max(afterIn-float64(reserveIn), 1)
+	gross := math.Ceil(netIn / l.gamma())
+	if gross >= math.MaxUint64 {
+		return nil, fmt.Errorf("output %d needs an input beyond uint64", outAmount)
+	}
+
+	// Step down while a smaller input still suffices, then up until one does
+	inAmount := uint64(gross)
+	pays := func(in uint64) bool {
+		result, err := l.swap(QuoteParams{InAmount: in, AToB: aToB}, false)
+		return err == nil && result.outAmount >= outAmount
+	}
+	for i := 0; i < exactOutSearchLimit && inAmount > 1 && pays(inAmount-1); i++ {
+		inAmount--
+	}
+	for i := 0; !pays(inAmount); i++ {
+		if i == exactOutSearchLimit {
+			return nil, fmt.Errorf("no input found paying out %d", outAmount)
+		}
+		inAmount++
+	}
+
+	return l.GetQuote(QuoteParams{InAmount: inAmount, AToB: aToB})
+}
+
+// QuoteReceipt records everything a quote was computed from, so it can be
+// serialized with encoding/json and replayed later with ReplayQuote.
+type QuoteReceipt struct {
+	Params QuoteParams
+	Pool   LifinityLiquidity // Reserves and settings before the swap
+	K      float64           // Invariant the swap priced against, Pool's A*B
+	At     time.Time         // When the quote was taken
+	Quote  Quote
+}
+
+// ErrReceiptMismatch is returned by ReplayQuote when the recomputed quote
+// differs from the recorded one.
+var ErrReceiptMismatch = errors.New("replayed quote does not match the receipt")
+
+// GetQuoteWithReceipt is GetQuote that also returns a receipt of the quote.
+func (l *LifinityLiquidity) GetQuoteWithReceipt(params QuoteParams) (*Quote, *QuoteReceipt, error) {
+	before := *l
+	before.history = nil
+
+	quote, err := l.GetQuote(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	return quote, &QuoteReceipt{Params: params, Pool: before, K: before.K(), At: time.Now(), Quote: *quote}, nil
+}
+
+// ReplayQuote recomputes a receipt's quote against its recorded pool and
+// returns ErrReceiptMismatch unless it equals the recorded one.
+func ReplayQuote(r QuoteReceipt) (*Quote, error) {
+	pool := r.Pool
+	quote, err := pool.SimulateQuote(r.Params)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	if *quote != r.Quote {
+		return quote, fmt.Errorf("%w: got %+v, recorded %+v", ErrReceiptMismatch, *quote, r.Quote)
+	}
+	return quote, nil
+}
+
+// SimulateQuote prices a swap exactly as GetQuote would, without executing it:
+// the reserves, and so K, are left untouched and repeated calls with the same
+// params return the same quote.
+func (l *LifinityLiquidity) SimulateQuote(params QuoteParams) (*Quote, error) {
+	result, err := l.swap(params, false)
+	if err != nil {
+		return nil, err
+	}
+	return l.quoteFor(params, result), nil
+}
+
+var _ venue.Quoter = (*LifinityLiquidity)(nil)
+
+// Quote implements venue.Quoter with SimulateQuote, so the reserves are left
+// untouched. in is rescaled to the input token's decimals, DecimalsA or
+// DecimalsB, truncating units the pool cannot take; the quote's InAmount is what
+// was actually priced.
+func (l *LifinityLiquidity) Quote(in venue.Amount, aToB bool) (venue.Quote, error) {
+	inDecimals, outDecimals := l.DecimalsB, l.DecimalsA
+	if aToB {
+		inDecimals, outDecimals = l.DecimalsA, l.DecimalsB
+	}
+	raw, err := in.Rescale(inDecimals)
+	if err != nil {
+		return venue.Quote{}, err
+	}
+	if raw.Sign() < 0 {
+		return venue.Quote{}, fmt.Errorf("invalid input amount %v", in)
+	}
+
+	quote, err := l.SimulateQuote(QuoteParams{InAmount: uint64(raw.Mantissa), AToB: aToB})
+	if err != nil {
+		return venue.Quote{}, err
+	}
+	if quote.OutAmount > math.MaxInt64 {
+		return venue.Quote{}, fmt.Errorf("%w: output %d", venue.ErrAmountOverflow, quote.OutAmount)
+	}
+	return venue.Quote{
+		InAmount:      raw,
+		OutAmount:     venue.Amount{Mantissa: int64(quote.OutAmount), Decimals: outDecimals},
+		AToB:          aToB,
+		FeeBps:        float64(l.FeeBps),
+		PriceImpactBP: quote.PriceImpactBP,
+	}, nil
+}
+
+// QuoteByReserveFraction prices, without executing, a swap whose input is
+// fraction of the input-side reserve.
+func (l *LifinityLiquidity) QuoteByReserveFraction(fraction float64, aToB bool) (*Quote, error) {
+	if fraction <= 0 || fraction >= 1 {
+		return nil, fmt.Errorf("fraction %v must be in (0, 1)", fraction)
+	}
+
+	reserveIn := l.B
+	if aToB {
+		reserveIn = l.A
+	}
+	inAmount := uint64(fraction * float64(reserveIn))
+	if inAmount == 0 {
+		return nil, errors.New("fraction of the reserve rounds to zero input")
+	}
+	return l.SimulateQuote(QuoteParams{InAmount: inAmount, AToB: aToB})
+}
+
+// Amm is a pool that can price swaps without executing them.
+type Amm interface {
+	SimulateQuote(params QuoteParams) (*Quote, error)
+	Prices() (baseInQuote float64, quoteInBase float64)
+}
+
+// BasketImpactBps quotes each trade against the pool named by its Pool field,
+// without executing, and returns the price impact averaged by notional value
+// in quote terms.
+func BasketImpactBps(trades []QuoteParams, pools map[string]Amm) (float64, error) {
+	var weightedImpact, totalNotional float64
+	for i, trade := range trades {
+		pool, ok := pools[trade.Pool]
+		if !ok {
+			return 0, fmt.Errorf("trade %d: unknown pool %q", i, trade.Pool)
+		}
+
+		quote, err := pool.SimulateQuote(trade)
+		if err != nil {
+			return 0, fmt.Errorf("trade %d: %w", i, err)
+		}
+
+		notional := float64(trade.InAmount)
+		if trade.AToB {
+			baseInQuote, _ := pool.Prices()
+			notional *= baseInQuote
+		}
+		weightedImpact += float64(quote.PriceImpactBP) * notional
+		totalNotional += notional
+	}
+
+	if totalNotional == 0 {
+		return 0, errors.New("basket has no notional")
+	}
+	return weightedImpact / totalNotional, nil
+}
+
+// ImpactTrajectory executes trades in order against a copy of the pool and
+// returns, after each one, how far the spot price (B/A) has moved from where it
+// started, in basis points. The pool itself is not modified.
+func (l *LifinityLiquidity) ImpactTrajectory(trades []QuoteParams) ([]float64, error) {
+	if l.A == 0 || l.B == 0 {
+		return nil, errors.New("pool has an empty reserve")
+	}
+
+	pool := *l
+	pool.ReadOnly = false
+	startPrice, _ := pool.Prices()
+
+	trajectory := make([]float64, len(trades))
+	for i, trade := range trades {
+		if _, err := pool.GetQuote(trade); err != nil {
+			return nil, fmt.Errorf("trade %d: %w", i, err)
+		}
+		price, _ := pool.Prices()
+		trajectory[i] = math.Abs(price-startPrice) / startPrice * 10_000
+	}
+	return trajectory, nil
+}
+
+// ApplyFraction executes fraction of a quote's input, and of its FlatFee,
+// against the pool, for quotes that were priced without executing and only
+// partly filled.
+func (l *LifinityLiquidity) ApplyFraction(q *Quote, fraction float64) error {
+	if l.ReadOnly {
+		return ErrReadOnly
+	}
+	if fraction <= 0 || fraction > 1 {
+		return fmt.Errorf("fraction %v must be in (0, 1]", fraction)
+	}
+
+	inAmount := uint64(float64(q.InAmount) * fraction)
+	if inAmount == 0 {
+		return errors.New("fraction of the quote rounds to zero input")
+	}
+	// The flat fee shrinks with the input so a B-to-A fill still swaps only what
+	// is left after it, as in the quote
+	flatFee := uint64(float64(q.FlatFee) * fraction)
+
+	result, err := l.swap(QuoteParams{InAmount: inAmount, AToB: q.AToB, FlatFee: flatFee}, false)
+	if err != nil {
+		return err
+	}
+	l.A = result.afterA
+	l.B = result.afterB
+	return nil
+}
+
+// ApplyTrade executes a quote that was priced without executing, such as one
+// from SimulateQuote.
+func (l *LifinityLiquidity) ApplyTrade(q *Quote) error {
+	return l.ApplyFraction(q, 1)
+}
+
+// LatencyAdjustedPrice returns q's average price in B per A, made worse by
+// LatencyPenaltyBps: lowered when selling A (A-to-B) and raised when buying it.
+func (l *LifinityLiquidity) LatencyAdjustedPrice(q *Quote) float64 {
+	if q.InAmount == 0 || q.OutAmount == 0 {
+		return 0
+	}
+	penalty := l.LatencyPenaltyBps / 10_000
+	if q.AToB {
+		return float64(q.OutAmount) / float64(q.InAmount) * (1 - penalty)
+	}
+	return float64(q.InAmount) / float64(q.OutAmount) * (1 + penalty)
+}
+
+// IsLargeSwap reports whether swapping inAmount would change either reserve by
+// more than thresholdPct percent. The pool is not modified.
+func (l *LifinityLiquidity) IsLargeSwap(inAmount uint64, aToB bool, thresholdPct float64) (bool, error) {
+	if thresholdPct <= 0 {
+		return false, errors.New("threshold must be greater than zero")
+	}
+	if l.A == 0 || l.B == 0 {
+		return false, errors.New("pool has an empty reserve")
+	}
+
+	result, err := l.swap(QuoteParams{InAmount: inAmount, AToB: aToB}, false)
+	if err != nil {
+		return false, err
+	}
+
+	changeA := math.Abs(float64(result.afterA)-float64(l.A)) / float64(l.A) * 100
+	changeB := math.Abs(float64(result.afterB)-float64(l.B)) / float64(l.B) * 100
+	return changeA > thresholdPct || changeB > thresholdPct, nil
+}
@@ -0,0 +1,1038 @@
+package lifinity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundingModes(t *testing.T) {
+	cases := []struct {
+		a, b, in           uint64
+		down, up, halfEven uint64
+	}{
+		{a: 1000, b: 20_000, in: 1, down: 19, up: 20, halfEven: 20}, // Exact output 19.98
+		{a: 1000, b: 1001, in: 40, down: 38, up: 39, halfEven: 38},  // 38.5 ties to even
+		{a: 10, b: 10, in: 30, down: 7, up: 8, halfEven: 8},         // 7.5 ties to even
+		{a: 1000, b: 2000, in: 1000, down: 1000, up: 1000, halfEven: 1000},
+	}
+	for _, c := range cases {
+		for rounding, want := range map[Rounding]uint64{RoundDown: c.down, RoundUp: c.up, RoundHalfEven: c.halfEven} {
+			pool := NewLifinityLiquidityWithFee(c.a, c.b, 0)
+			pool.Rounding = rounding
+			q, err := pool.GetQuote(QuoteParams{InAmount: c.in, AToB: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.OutAmount != want || pool.B != c.b-want {
+				t.Errorf("pool %d/%d, in %d, rounding %d: out = %d, B = %d; want %d", c.a, c.b, c.in, rounding, q.OutAmount, pool.B, want)
+			}
+		}
+	}
+}
+
+func TestReserveLimited(t *testing.T) {
+	for _, rounding := range []Rounding{RoundDown, RoundUp, RoundHalfEven} {
+		pool := NewLifinityLiquidity(10, 1000)
+		pool.Rounding = rounding
+		q, err := pool.SimulateQuote(QuoteParams{InAmount: 1 << 40, AToB: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.OutAmount != 999 || !q.ReserveLimited {
+			t.Errorf("rounding %d: quote = %+v, want 999 out, reserve limited", rounding, q)
+		}
+	}
+
+	q, err := NewLifinityLiquidity(1000, 20_000).SimulateQuote(QuoteParams{InAmount: 10, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.ReserveLimited {
+		t.Errorf("small swap = %+v, want it not reserve limited", q)
+	}
+}
+
+func TestRecordReservesOrder(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	if err := pool.RecordReservesAt(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.RecordReserves(); err == nil {
+		t.Error("RecordReserves accepted an observation older than the last one")
+	}
+}
+
+func TestGetQuoteNeverExceedsMaxOutput(t *testing.T) {
+	for _, rounding := range []Rounding{RoundDown, RoundUp, RoundHalfEven} {
+		for _, aToB := range []bool{true, false} {
+			for _, inAmount := range []uint64{5, 1000, 1 << 20, 1 << 40, 1 << 62} {
+				pool := NewLifinityLiquidity(10, 1000)
+				pool.Rounding = rounding
+				limit := pool.MaxOutput(aToB)
+				q, err := pool.SimulateQuote(QuoteParams{InAmount: inAmount, AToB: aToB})
+				if err != nil {
+					continue // Too small to buy anything
+				}
+				if q.OutAmount > limit {
+					t.Errorf("rounding %d, aToB %v, in %d: out = %d, above MaxOutput %d", rounding, aToB, inAmount, q.OutAmount, limit)
+				}
+			}
+		}
+	}
+
+	pool := NewLifinityLiquidity(10, 1000)
+	if pool.MaxOutput(true) != 999 || pool.MaxOutput(false) != 9 {
+		t.Errorf("MaxOutput = %d, %d; want 999, 9", pool.MaxOutput(true), pool.MaxOutput(false))
+	}
+	if NewLifinityLiquidity(10, 0).MaxOutput(true) != 0 {
+		t.Error("an empty output reserve can pay out")
+	}
+}
+
+func TestAbsoluteMinOut(t *testing.T) {
+	// 10 in pays 178 out of a 1000/20000 pool
+	pool := NewLifinityLiquidity(1000, 20_000)
+	_, err := pool.GetQuote(QuoteParams{InAmount: 10, AToB: true, AbsoluteMinOut: 179})
+	if !errors.Is(err, ErrBelowFloor) || errors.Is(err, ErrSlippageExceeded) {
+		t.Errorf("err = %v, want ErrBelowFloor only", err)
+	}
+	if pool.A != 1000 || pool.B != 20_000 {
+		t.Error("a swap below the floor moved the reserves")
+	}
+	if q, err := pool.GetQuote(QuoteParams{InAmount: 10, AToB: true, AbsoluteMinOut: 178}); err != nil || q.OutAmount != 178 {
+		t.Errorf("swap at the floor = %+v, %v", q, err)
+	}
+}
+
+func TestIsLargeSwapBoundary(t *testing.T) {
+	pool := NewLifinityLiquidityWithFee(1000, 1000, 0)
+	for _, c := range []struct {
+		in   uint64
+		want bool
+	}{
+		{in: 499, want: false},
+		{in: 500, want: false}, // A grows by exactly 50%
+		{in: 501, want: true},
+	} {
+		large, err := pool.IsLargeSwap(c.in, true, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if large != c.want {
+			t.Errorf("in %d: large = %v, want %v", c.in, large, c.want)
+		}
+	}
+	if pool.A != 1000 || pool.B != 1000 {
+		t.Error("IsLargeSwap modified the pool")
+	}
+}
+
+func TestQuoteHash(t *testing.T) {
+	q := &Quote{InAmount: 1000, OutAmount: 9_960, PriceImpactBP: 48, AToB: true}
+	if q.Hash() != (&Quote{InAmount: 1000, OutAmount: 9_960, PriceImpactBP: 48, AToB: true}).Hash() {
+		t.Error("equal quotes hash differently")
+	}
+	for name, change := range map[string]func(*Quote){
+		"InAmount":       func(q *Quote) { q.InAmount++ },
+		"OutAmount":      func(q *Quote) { q.OutAmount++ },
+		"PriceImpactBP":  func(q *Quote) { q.PriceImpactBP++ },
+		"FeeAmount":      func(q *Quote) { q.FeeAmount = 5 },
+		"FlatFee":        func(q *Quote) { q.FlatFee = 5 },
+		"AToB":           func(q *Quote) { q.AToB = false },
+		"ReserveLimited": func(q *Quote) { q.ReserveLimited = true },
+	} {
+		changed := *q
+		change(&changed)
+		if changed.Hash() == q.Hash() {
+			t.Errorf("changing %s leaves the hash unchanged", name)
+		}
+	}
+}
+
+func TestRoundTripFeeDragBps(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	for _, c := range []struct {
+		size uint64
+		want float64
+	}{
+		{size: 10_000, want: 100},      // 50 in, then 49.75 on 9950 rounds up to 50
+		{size: 1_000_000, want: 99.75}, // 5000, then 4975 on 995000
+		{size: 1, want: 10_000},        // A single unit pays a whole unit on entry
+		{size: 0, want: 0},
+	} {
+		if got := pool.RoundTripFeeDragBps(c.size); got != c.want {
+			t.Errorf("size %d: drag = %v bps, want %v", c.size, got, c.want)
+		}
+	}
+	if got := NewLifinityLiquidityWithFee(1000, 20_000, 0).RoundTripFeeDragBps(10_000); got != 0 {
+		t.Errorf("fee-free pool: drag = %v bps, want 0", got)
+	}
+}
+
+func TestPricesAreReciprocal(t *testing.T) {
+	for _, pool := range []*LifinityLiquidity{
+		NewLifinityLiquidity(1000, 20_000),
+		NewLifinityLiquidity(3, 7),
+		NewLifinityLiquidity(1_000_000_000_000, 20_000_000_000),
+	} {
+		baseInQuote, quoteInBase := pool.Prices()
+		if baseInQuote != float64(pool.B)/float64(pool.A) {
+			t.Errorf("%d/%d: base in quote = %v, want B/A", pool.A, pool.B, baseInQuote)
+		}
+		if math.Abs(baseInQuote*quoteInBase-1) > 1e-12 {
+			t.Errorf("%d/%d: prices %v and %v are not reciprocal", pool.A, pool.B, baseInQuote, quoteInBase)
+		}
+	}
+}
+
+func TestBasketImpactBps(t *testing.T) {
+	x, y := NewLifinityLiquidity(1000, 20_000), NewLifinityLiquidity(10_000, 10_000)
+	pools := map[string]Amm{"x": x, "y": y}
+	// 10 A into x is worth 200 B, the same as 200 B into y, so the basket
+	// impact is the plain mean of the two
+	trades := []QuoteParams{
+		{Pool: "x", InAmount: 10, AToB: true},
+		{Pool: "y", InAmount: 200, AToB: false},
+	}
+	qx, err := x.SimulateQuote(trades[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	qy, err := y.SimulateQuote(trades[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qx.PriceImpactBP == qy.PriceImpactBP {
+		t.Fatalf("both trades move their pool by %d bps; pick trades that differ", qx.PriceImpactBP)
+	}
+
+	got, err := BasketImpactBps(trades, pools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := float64(qx.PriceImpactBP+qy.PriceImpactBP) / 2; got != want {
+		t.Errorf("basket impact = %v bps, want %v", got, want)
+	}
+	if x.A != 1000 || x.B != 20_000 || y.A != 10_000 || y.B != 10_000 {
+		t.Error("BasketImpactBps executed a trade")
+	}
+
+	missing := append(trades, QuoteParams{Pool: "z", InAmount: 10, AToB: true})
+	if _, err := BasketImpactBps(missing, pools); err == nil || !strings.Contains(err.Error(), `"z"`) {
+		t.Errorf("missing pool: err = %v, want it named", err)
+	}
+}
+
+func TestQuoteByReserveFraction(t *testing.T) {
+	pool := NewLifinityLiquidityWithFee(1000, 4000, 0)
+	for _, c := range []struct {
+		fraction float64
+		aToB     bool
+		in, out  uint64
+	}{
+		{fraction: 0.01, aToB: true, in: 10, out: 39},   // 4000*10/1010 = 39.6
+		{fraction: 0.1, aToB: true, in: 100, out: 363},  // 4000*100/1100 = 363.6
+		{fraction: 0.5, aToB: true, in: 500, out: 1333}, // 4000*500/1500 = 1333.3
+		{fraction: 0.1, aToB: false, in: 400, out: 90},  // 1000*400/4400 = 90.9
+	} {
+		q, err := pool.QuoteByReserveFraction(c.fraction, c.aToB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.InAmount != c.in || q.OutAmount != c.out {
+			t.Errorf("fraction %v, aToB %v: quote %d in, %d out; want %d, %d", c.fraction, c.aToB, q.InAmount, q.OutAmount, c.in, c.out)
+		}
+	}
+	if pool.A != 1000 || pool.B != 4000 {
+		t.Error("QuoteByReserveFraction executed a trade")
+	}
+
+	for _, fraction := range []float64{0, 1, -0.1, 0.0001} {
+		if _, err := pool.QuoteByReserveFraction(fraction, true); err == nil {
+			t.Errorf("fraction %v was accepted", fraction)
+		}
+	}
+}
+
+func TestLiquidityInBand(t *testing.T) {
+	// Price 4 on K = 4e12: a 21% rise takes B to sqrt(4e12*4*1.21) = 4.4e6 and
+	// a 19% fall takes it to 3.6e6
+	pool := NewLifinityLiquidityWithFee(1_000_000, 4_000_000, 0)
+	near := func(got, want uint64) bool { return got+1 >= want && got <= want+1 }
+	if buyable, _ := pool.LiquidityInBand(2100); !near(buyable, 400_000) {
+		t.Errorf("buyable within 21%% = %d, want 400000", buyable)
+	}
+	if _, sellable := pool.LiquidityInBand(1900); !near(sellable, 400_000) {
+		t.Errorf("sellable within 19%% = %d, want 400000", sellable)
+	}
+
+	// With a fee, buying the buyable amount lands on the band edge
+	pool = NewLifinityLiquidityWithFee(1_000_000, 4_000_000, 30)
+	buyable, _ := pool.LiquidityInBand(100)
+	if _, err := pool.GetQuote(QuoteParams{InAmount: buyable, AToB: false}); err != nil {
+		t.Fatal(err)
+	}
+	if moved := (float64(pool.B)/float64(pool.A)/4 - 1) * 10_000; math.Abs(moved-100) > 0.1 {
+		t.Errorf("buying %d moved the price %v bps, want 100", buyable, moved)
+	}
+
+	for _, bps := range []float64{0, -5, 10_000} {
+		if buyable, sellable := pool.LiquidityInBand(bps); buyable != 0 || sellable != 0 {
+			t.Errorf("band %v bps = %d, %d; want 0, 0", bps, buyable, sellable)
+		}
+	}
+}
+
+func TestReserveFloors(t *testing.T) {
+	for _, c := range []struct {
+		aToB      bool
+		in        uint64
+		breaching bool
+	}{
+		{aToB: true, in: 100, breaching: false}, // Pays 90, B stays at 910
+		{aToB: true, in: 120, breaching: true},  // Pays 107, B would be 893
+		{aToB: false, in: 50, breaching: false}, // Pays 47, A stays at 953
+		{aToB: false, in: 60, breaching: true},  // Pays 56, A would be 944
+	} {
+		pool := NewLifinityLiquidityWithFee(1000, 1000, 0)
+		pool.MinReserveA, pool.MinReserveB = 950, 900
+		_, err := pool.GetQuote(QuoteParams{InAmount: c.in, AToB: c.aToB})
+		if c.breaching {
+			if !errors.Is(err, ErrReserveFloorBreached) {
+				t.Errorf("aToB %v, in %d: err = %v, want ErrReserveFloorBreached", c.aToB, c.in, err)
+			}
+			if pool.A != 1000 || pool.B != 1000 {
+				t.Errorf("aToB %v, in %d: a rejected swap moved the reserves", c.aToB, c.in)
+			}
+		} else if err != nil {
+			t.Errorf("aToB %v, in %d: %v", c.aToB, c.in, err)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	pool.ReadOnly = true
+
+	q, err := pool.GetQuote(QuoteParams{InAmount: 10, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.OutAmount == 0 || pool.A != 1000 || pool.B != 20_000 {
+		t.Errorf("read-only GetQuote = %+v, reserves %d/%d; want a quote and unchanged reserves", q, pool.A, pool.B)
+	}
+	if err := pool.ApplyTrade(q); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("ApplyTrade err = %v, want ErrReadOnly", err)
+	}
+	if err := pool.ApplyFraction(q, 0.5); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("ApplyFraction err = %v, want ErrReadOnly", err)
+	}
+	if pool.A != 1000 || pool.B != 20_000 {
+		t.Error("a read-only pool was traded against")
+	}
+}
+
+func TestProjectedFeeRevenue(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000) // 50 bps
+	for _, c := range []struct{ volume, want uint64 }{
+		{volume: 1_000_000, want: 5_000},
+		{volume: 12_345, want: 61}, // 61.725, truncated
+		{volume: 0, want: 0},
+		{volume: math.MaxUint64, want: math.MaxUint64 / 200},
+	} {
+		if got := pool.ProjectedFeeRevenue(c.volume); got != c.want {
+			t.Errorf("volume %d: revenue = %d, want %d", c.volume, got, c.want)
+		}
+	}
+}
+
+func TestFormatSolUsdc(t *testing.T) {
+	for _, c := range []struct {
+		q       Quote
+		in, out string
+	}{
+		// Sell 1.5 SOL for 29.85 USDC
+		{q: Quote{InAmount: 1_500_000_000, OutAmount: 29_850_000, AToB: true}, in: "1.5", out: "29.85"},
+		// Buy 0.000000042 SOL with 1 USDC
+		{q: Quote{InAmount: 1_000_000, OutAmount: 42}, in: "1", out: "0.000000042"},
+		{q: Quote{InAmount: 7, OutAmount: 0, AToB: true}, in: "0.000000007", out: "0"},
+	} {
+		in, out := c.q.Format(9, 6)
+		if in != c.in || out != c.out {
+			t.Errorf("%+v formats as %q, %q; want %q, %q", c.q, in, out, c.in, c.out)
+		}
+	}
+}
+
+func TestImpactHalfLife(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	if got := pool.ImpactAfter(80, 30); got != 80 {
+		t.Errorf("impact without decay = %v, want 80", got)
+	}
+
+	pool.ImpactDecay(10)
+	for _, c := range []struct{ elapsed, want float64 }{
+		{elapsed: 0, want: 80},
+		{elapsed: 10, want: 40},
+		{elapsed: 20, want: 20},
+		{elapsed: 35, want: 80 / (8 * math.Sqrt2)},
+	} {
+		if got := pool.ImpactAfter(80, c.elapsed); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("after %vs: impact = %v bps, want %v", c.elapsed, got, c.want)
+		}
+	}
+	if pool.A != 1000 || pool.B != 20_000 {
+		t.Error("ImpactAfter touched the reserves")
+	}
+}
+
+func TestTwapQuoteDiffersFromSpot(t *testing.T) {
+	// B sat at 10000 for the first half of the window and at 40000 since, so the
+	// TWAP reserves are 10000/25000 while spot is 10000/40000
+	now := time.Now()
+	pool := NewLifinityLiquidity(10_000, 10_000)
+	if err := pool.RecordReservesAt(now.Add(-100 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	pool.B = 40_000
+	if err := pool.RecordReservesAt(now.Add(-50 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if a, b, err := pool.twapReserves(100, now); err != nil || a != 10_000 || b != 25_000 {
+		t.Fatalf("TWAP reserves = %d/%d, %v; want 10000/25000", a, b, err)
+	}
+
+	params := QuoteParams{InAmount: 100, AToB: true}
+	twap, err := pool.TwapQuote(params, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spot, err := pool.SimulateQuote(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewLifinityLiquidity(10_000, 25_000).SimulateQuote(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if twap.OutAmount != want.OutAmount || twap.OutAmount >= spot.OutAmount {
+		t.Errorf("TWAP out = %d, spot out = %d; want %d, below spot", twap.OutAmount, spot.OutAmount, want.OutAmount)
+	}
+	if pool.A != 10_000 || pool.B != 40_000 {
+		t.Error("TwapQuote executed a trade")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// Swapping a whole reserve into a 1000/20000 pool has an impact of about
+	// 29800 bps either way, which is still a valid quote
+	for _, params := range []QuoteParams{{InAmount: 1000, AToB: true}, {InAmount: 20_000}} {
+		q, err := NewLifinityLiquidity(1000, 20_000).SimulateQuote(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Validate(); err != nil {
+			t.Errorf("quote %+v: %v", q, err)
+		}
+	}
+
+	for name, q := range map[string]Quote{
+		"zero input":          {OutAmount: 1},
+		"zero output":         {InAmount: 1},
+		"fee above the input": {InAmount: 10, OutAmount: 1, FeeAmount: 11},
+	} {
+		if err := q.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted %+v", name, q)
+		}
+	}
+}
+
+func TestMarginalCostCurve(t *testing.T) {
+	// Fee-free, so the marginal cost after in is (1000+in)²/1e6
+	pool := NewLifinityLiquidityWithFee(1000, 1000, 0)
+	curve, err := pool.MarginalCostCurve(1000, 4, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1.5625, 2.25, 3.0625, 4}
+	if len(curve) != len(want) {
+		t.Fatalf("curve = %v, want %v", curve, want)
+	}
+	for i := range want {
+		if math.Abs(curve[i]-want[i]) > 1e-12 {
+			t.Errorf("curve = %v, want %v", curve, want)
+			break
+		}
+	}
+
+	// A fee raises every point but keeps the curve rising
+	feeCurve, err := NewLifinityLiquidity(1000, 1000).MarginalCostCurve(1000, 4, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range feeCurve {
+		if feeCurve[i] <= curve[i] || (i > 0 && feeCurve[i] <= feeCurve[i-1]) {
+			t.Errorf("curve with a fee = %v, want above %v and rising", feeCurve, curve)
+			break
+		}
+	}
+
+	if _, err := pool.MarginalCostCurve(1000, 0, true); err == nil {
+		t.Error("zero steps were accepted")
+	}
+	if _, err := NewLifinityLiquidity(0, 1000).MarginalCostCurve(1000, 4, true); err == nil {
+		t.Error("an empty pool has a cost curve")
+	}
+}
+
+func TestMinNotional(t *testing.T) {
+	for _, c := range []struct {
+		params QuoteParams
+		below  bool
+	}{
+		{params: QuoteParams{InAmount: 24, AToB: true}, below: true},  // 480 B at 20 B per A
+		{params: QuoteParams{InAmount: 25, AToB: true}, below: false}, // 500 B
+		{params: QuoteParams{InAmount: 499}, below: true},
+		{params: QuoteParams{InAmount: 500}, below: false},
+	} {
+		pool := NewLifinityLiquidity(1000, 20_000)
+		pool.MinNotional = 500
+		_, err := pool.GetQuote(c.params)
+		if c.below {
+			if !errors.Is(err, ErrBelowMinNotional) {
+				t.Errorf("%+v: err = %v, want ErrBelowMinNotional", c.params, err)
+			}
+			if pool.A != 1000 || pool.B != 20_000 {
+				t.Errorf("%+v: a rejected swap moved the reserves", c.params)
+			}
+		} else if err != nil {
+			t.Errorf("%+v: %v", c.params, err)
+		}
+	}
+}
+
+func TestPriceForMarginalOutputInvertsMarginalPrice(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	gamma := 1 - float64(pool.FeeBps)/10_000
+	for _, aToB := range []bool{true, false} {
+		for _, in := range []uint64{0, 100, 1000, 50_000} {
+			// The curve state after in: the input reserve grows by γ*in and
+			// the output reserve keeps K
+			reserveIn, reserveOut := float64(pool.A), float64(pool.B)
+			if !aToB {
+				reserveIn, reserveOut = reserveOut, reserveIn
+			}
+			reserveIn += gamma * float64(in)
+			reserveOut = pool.K() / reserveIn
+			spot := reserveOut / reserveIn
+			if !aToB {
+				spot = reserveIn / reserveOut
+			}
+
+			got := pool.PriceForMarginalOutput(1/pool.MarginalPriceAfter(in, aToB), aToB)
+			if math.Abs(got-spot) > 1e-9*spot {
+				t.Errorf("aToB %v, after %d: price = %v, want spot %v", aToB, in, got, spot)
+			}
+		}
+	}
+	if got := pool.PriceForMarginalOutput(0, true); got != 0 {
+		t.Errorf("zero output per unit: price = %v, want 0", got)
+	}
+}
+
+func TestFlatAndPoolFeesBothDeducted(t *testing.T) {
+	// B to A: the flat fee comes off the input before the 50 bps pool fee
+	params := QuoteParams{InAmount: 100_000, FlatFee: 50_000}
+	executed := NewLifinityLiquidity(1_000_000, 20_000_000)
+	q, err := executed.GetQuote(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.FlatFee != 50_000 || q.FeeAmount != 250 {
+		t.Errorf("fees = %d flat, %d pool; want 50000, 250", q.FlatFee, q.FeeAmount)
+	}
+	if executed.B != 20_049_750 {
+		t.Errorf("B = %d, want 20049750: only the 49750 left after both fees reaches the pool", executed.B)
+	}
+
+	// Applying the simulated quote moves the pool exactly like executing it
+	applied := NewLifinityLiquidity(1_000_000, 20_000_000)
+	simulated, err := applied.SimulateQuote(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applied.ApplyTrade(simulated); err != nil {
+		t.Fatal(err)
+	}
+	if applied.A != executed.A || applied.B != executed.B {
+		t.Errorf("ApplyTrade left %d/%d, GetQuote %d/%d", applied.A, applied.B, executed.A, executed.B)
+	}
+
+	// Half the quote swaps half of each amount
+	half := NewLifinityLiquidity(1_000_000, 20_000_000)
+	if err := half.ApplyFraction(simulated, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if half.B != 20_024_875 {
+		t.Errorf("half applied: B = %d, want 20024875", half.B)
+	}
+
+	// A to B: the pool fee comes off the input and the flat fee off the output
+	pool := NewLifinityLiquidity(1_000_000, 20_000_000)
+	withFlat, err := pool.SimulateQuote(QuoteParams{InAmount: 1000, AToB: true, FlatFee: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutFlat, err := pool.SimulateQuote(QuoteParams{InAmount: 1000, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withFlat.FeeAmount != 5 || withFlat.OutAmount != withoutFlat.OutAmount-100 {
+		t.Errorf("with flat fee = %+v, without = %+v; want 5 pool fee and 100 less out", withFlat, withoutFlat)
+	}
+}
+
+func TestBalancingSwapReachesTarget(t *testing.T) {
+	for _, target := range []float64{10, 19.5, 25, 80} {
+		pool := NewLifinityLiquidity(1_000_000_000, 20_000_000_000)
+		inAmount, aToB, err := pool.BalancingSwap(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if aToB != (target < 20) {
+			t.Errorf("target %v: aToB = %v", target, aToB)
+		}
+		if _, err := pool.GetQuote(QuoteParams{InAmount: inAmount, AToB: aToB}); err != nil {
+			t.Fatal(err)
+		}
+		price, _ := pool.Prices()
+		if bps := math.Abs(price/target-1) * 10_000; bps > 1 {
+			t.Errorf("target %v: swapping %d lands at %v, %v bps away", target, inAmount, price, bps)
+		}
+	}
+
+	pool := NewLifinityLiquidity(1000, 20_000)
+	if inAmount, _, err := pool.BalancingSwap(20); err != nil || inAmount != 0 {
+		t.Errorf("at the target: swap = %d, %v; want 0", inAmount, err)
+	}
+	if _, _, err := pool.BalancingSwap(math.NaN()); err == nil {
+		t.Error("a NaN target was accepted")
+	}
+}
+
+func TestOutputRangeWidens(t *testing.T) {
+	pool := NewLifinityLiquidity(1_000_000, 20_000_000)
+	narrowMin, narrowMax, narrowMid, err := pool.OutputRange(10_000, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wideMin, wideMax, wideMid, err := pool.OutputRange(10_000, true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !(narrowMin <= narrowMid && narrowMid <= narrowMax) {
+		t.Errorf("narrow range %d..%d does not contain mid %d", narrowMin, narrowMax, narrowMid)
+	}
+	if narrowMid != wideMid {
+		t.Errorf("mid moved with the uncertainty: %d vs %d", narrowMid, wideMid)
+	}
+	if wideMin >= narrowMin || wideMax <= narrowMax {
+		t.Errorf("range at 10%% = %d..%d, want wider than %d..%d at 1%%", wideMin, wideMax, narrowMin, narrowMax)
+	}
+	if pool.A != 1_000_000 || pool.B != 20_000_000 {
+		t.Error("OutputRange modified the pool")
+	}
+}
+
+func TestSmallPoolDoesNotWrap(t *testing.T) {
+	// A 1-unit input pays a 1-unit fee and leaves the reserve where it was
+	pool := NewLifinityLiquidity(10, 10)
+	if _, err := pool.GetQuote(QuoteParams{InAmount: 1, AToB: true}); !errors.Is(err, ErrInsufficientOutput) {
+		t.Errorf("err = %v, want ErrInsufficientOutput", err)
+	}
+	if pool.A != 10 || pool.B != 10 {
+		t.Errorf("failed swap moved the reserves to %d/%d", pool.A, pool.B)
+	}
+
+	// 5 in, 1 of it fee: B = 100/14 = 7.14 after the swap, so 2 whole units out
+	for _, aToB := range []bool{true, false} {
+		pool := NewLifinityLiquidity(10, 10)
+		q, err := pool.GetQuote(QuoteParams{InAmount: 5, AToB: aToB})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.OutAmount != 2 {
+			t.Errorf("aToB %v: out = %d, want 2", aToB, q.OutAmount)
+		}
+	}
+}
+
+// referenceOut computes what OutAmount128 should return under RoundDown with
+// math/big: the output reserve minus the ceiling of K over the new input
+// reserve, capped at the output reserve minus one. ok is false when the swap
+// pays out nothing.
+func referenceOut(reserveIn, reserveOut, inAmount uint64, feeBps uint) (out uint64, ok bool) {
+	in := new(big.Int).SetUint64(inAmount)
+	fee := new(big.Int).Mul(in, big.NewInt(int64(feeBps)))
+	fee.Add(fee, big.NewInt(9_999)).Quo(fee, big.NewInt(10_000))
+
+	afterIn := new(big.Int).SetUint64(reserveIn)
+	afterIn.Add(afterIn, in).Sub(afterIn, fee)
+	k := new(big.Int).Mul(new(big.Int).SetUint64(reserveIn), new(big.Int).SetUint64(reserveOut))
+	ceil, remainder := new(big.Int).QuoRem(k, afterIn, new(big.Int))
+	if remainder.Sign() > 0 {
+		ceil.Add(ceil, big.NewInt(1))
+	}
+
+	if ceil.Cmp(new(big.Int).SetUint64(reserveOut)) >= 0 {
+		return 0, false
+	}
+	out = reserveOut - ceil.Uint64()
+	return min(out, reserveOut-1), out > 0
+}
+
+func TestOutAmount128MatchesBigInt(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10_000; i++ {
+		// Keep the input reserve plus the input within uint64
+		a, b := rng.Uint64()>>2+1, rng.Uint64()>>(2+rng.Intn(62))+1
+		inAmount := rng.Uint64() >> (1 + rng.Intn(63))
+		aToB := rng.Intn(2) == 0
+		pool := NewLifinityLiquidity(a, b)
+
+		reserveIn, reserveOut := b, a
+		if aToB {
+			reserveIn, reserveOut = a, b
+		}
+		want, ok := referenceOut(reserveIn, reserveOut, inAmount, pool.FeeBps)
+		got, err := pool.OutAmount128(inAmount, aToB)
+		if !ok {
+			if err == nil {
+				t.Fatalf("pool %d/%d, in %d, aToB %v: out = %d, want an error", a, b, inAmount, aToB, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Fatalf("pool %d/%d, in %d, aToB %v: out = %d, %v; want %d", a, b, inAmount, aToB, got, err, want)
+		}
+
+		// The math/big swap path agrees
+		result, err := pool.swap(QuoteParams{InAmount: inAmount, AToB: aToB}, true)
+		if err == nil && result.outAmount != want {
+			t.Fatalf("pool %d/%d, in %d, aToB %v: precise swap = %d, want %d", a, b, inAmount, aToB, result.outAmount, want)
+		}
+	}
+}
+
+func BenchmarkOutAmount128(b *testing.B) {
+	pool := NewLifinityLiquidity(1<<62+12_345, 1<<61+67_890)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := pool.OutAmount128(1<<40, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOutAmountBigInt(b *testing.B) {
+	pool := NewLifinityLiquidity(1<<62+12_345, 1<<61+67_890)
+	params := QuoteParams{InAmount: 1 << 40, AToB: true}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := pool.swap(params, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestImpactTrajectory(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	trades := []QuoteParams{{InAmount: 10, AToB: true}, {InAmount: 10, AToB: true}, {InAmount: 10, AToB: true}}
+	trajectory, err := pool.ImpactTrajectory(trades)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trajectory) != len(trades) || trajectory[0] <= 0 {
+		t.Fatalf("trajectory = %v", trajectory)
+	}
+	for i := 1; i < len(trajectory); i++ {
+		if trajectory[i] <= trajectory[i-1] {
+			t.Errorf("trajectory = %v, want strictly increasing", trajectory)
+		}
+	}
+	if pool.A != 1000 || pool.B != 20_000 {
+		t.Error("ImpactTrajectory modified the pool")
+	}
+}
+
+func TestGetQuoteExactOutRoundTrip(t *testing.T) {
+	exactIn := NewLifinityLiquidity(1_000_000, 20_000_000)
+	q, err := exactIn.GetQuote(QuoteParams{InAmount: 1000, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewLifinityLiquidity(1_000_000, 20_000_000)
+	back, err := pool.GetQuoteExactOut(q.OutAmount, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.InAmount > 1000 || 1000-back.InAmount > 5 || back.OutAmount < q.OutAmount {
+		t.Errorf("exact-out of %d = %+v, want about 1000 in", q.OutAmount, back)
+	}
+	if pool.A != 1_000_000+back.InAmount-back.FeeAmount || pool.B != 20_000_000-back.OutAmount {
+		t.Errorf("reserves = %d/%d after %+v", pool.A, pool.B, back)
+	}
+
+	if _, err := pool.GetQuoteExactOut(pool.B, true); !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("draining exact-out: err = %v, want ErrInsufficientLiquidity", err)
+	}
+}
+
+func TestFeeTiers(t *testing.T) {
+	low, err := NewLifinityLiquidityWithFee(1_000_000, 20_000_000, 50).GetQuote(QuoteParams{InAmount: 10_000, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := NewLifinityLiquidityWithFee(1_000_000, 20_000_000, 100).GetQuote(QuoteParams{InAmount: 10_000, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if high.OutAmount >= low.OutAmount || high.FeeAmount != 100 || low.FeeAmount != 50 {
+		t.Errorf("100 bps = %+v, 50 bps = %+v", high, low)
+	}
+}
+
+func TestBreakEvenFeeBps(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	for _, c := range []struct {
+		vol    float64
+		volume uint64
+		want   float64
+	}{
+		{vol: 0, volume: 20_000, want: 0},
+		{vol: 100, volume: 20_000, want: 0.25},
+		{vol: 1000, volume: 20_000, want: 25},
+		{vol: 1000, volume: 2000, want: 250}, // A tenth of the volume needs ten times the fee
+	} {
+		got, err := pool.BreakEvenFeeBps(c.vol, c.volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("volatility %v bps, volume %d: fee = %v bps, want %v", c.vol, c.volume, got, c.want)
+		}
+
+		// At that fee, the fees on the volume match the LVR of σ²/8 on the
+		// pool's 2B of value
+		sigma := c.vol / 10_000
+		if fees, lvr := got/10_000*float64(c.volume), sigma*sigma/8*2*float64(pool.B); math.Abs(fees-lvr) > 1e-9 {
+			t.Errorf("volatility %v bps, volume %d: fees %v, LVR %v", c.vol, c.volume, fees, lvr)
+		}
+	}
+
+	if _, err := pool.BreakEvenFeeBps(100, 0); err == nil {
+		t.Error("zero volume has a break-even fee")
+	}
+}
+
+func TestSmallSwapsPayAFee(t *testing.T) {
+	q, err := NewLifinityLiquidity(1_000_000, 20_000_000).GetQuote(QuoteParams{InAmount: 100, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.FeeAmount != 1 {
+		t.Errorf("fee = %d, want 1", q.FeeAmount)
+	}
+}
+
+func TestQuoteReceiptRoundTrip(t *testing.T) {
+	pool := NewLifinityLiquidity(1_000_000, 20_000_000)
+	_, receipt, err := pool.GetQuoteWithReceipt(QuoteParams{InAmount: 1000, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded QuoteReceipt
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Pool.A != 1_000_000 || decoded.K != 2e13 {
+		t.Errorf("receipt pool = %+v, K = %v; want the reserves before the swap", decoded.Pool, decoded.K)
+	}
+	if _, err := ReplayQuote(decoded); err != nil {
+		t.Errorf("replay: %v", err)
+	}
+
+	decoded.Quote.OutAmount++
+	if _, err := ReplayQuote(decoded); !errors.Is(err, ErrReceiptMismatch) {
+		t.Errorf("tampered replay: err = %v, want ErrReceiptMismatch", err)
+	}
+}
+
+func TestMinOutAmount(t *testing.T) {
+	simulated, err := NewLifinityLiquidity(1000, 20_000).SimulateQuote(QuoteParams{InAmount: 10, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewLifinityLiquidity(1000, 20_000).GetQuote(QuoteParams{InAmount: 10, AToB: true, MinOutAmount: simulated.OutAmount}); err != nil {
+		t.Errorf("satisfied bound: %v", err)
+	}
+
+	pool := NewLifinityLiquidity(1000, 20_000)
+	_, err = pool.GetQuote(QuoteParams{InAmount: 10, AToB: true, MinOutAmount: simulated.OutAmount + 1})
+	var slippage *SlippageError
+	if !errors.Is(err, ErrSlippageExceeded) || !errors.As(err, &slippage) {
+		t.Fatalf("err = %v, want a *SlippageError", err)
+	}
+	if slippage.OutAmount != simulated.OutAmount || slippage.MinOutAmount != simulated.OutAmount+1 {
+		t.Errorf("slippage error = %+v", slippage)
+	}
+	if pool.A != 1000 || pool.B != 20_000 {
+		t.Error("a rejected swap moved the reserves")
+	}
+}
+
+func TestAverageFillPrice(t *testing.T) {
+	sell := &Quote{InAmount: 10, OutAmount: 178, AToB: true}
+	buy := &Quote{InAmount: 200, OutAmount: 9}
+	if price, err := sell.AverageFillPrice(); err != nil || price != 17.8 {
+		t.Errorf("sell price = %v, %v; want 17.8", price, err)
+	}
+	if price, err := buy.AverageFillPrice(); err != nil || price != 200.0/9 {
+		t.Errorf("buy price = %v, %v; want %v", price, err, 200.0/9)
+	}
+	if _, err := (&Quote{}).AverageFillPrice(); err == nil {
+		t.Error("expected an error for an empty quote")
+	}
+}
+
+func TestQuoteJSONRoundTrip(t *testing.T) {
+	q, err := NewLifinityLiquidity(1000, 20_000).SimulateQuote(QuoteParams{InAmount: 10, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"inAmount"`, `"outAmount"`, `"priceImpactBp"`} {
+		if !bytes.Contains(data, []byte(key)) {
+			t.Errorf("quote JSON %s has no %s key", data, key)
+		}
+	}
+	var decoded Quote
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != *q {
+		t.Errorf("decoded quote = %+v, want %+v", decoded, *q)
+	}
+}
+
+func TestGetQuotePreciseLargeReserves(t *testing.T) {
+	const a, b = 999_999_999_999_999_989, 123_456_789_012_345_678
+	params := QuoteParams{InAmount: 1_000_000_000_000_007, AToB: true}
+
+	precisePool := NewLifinityLiquidity(a, b)
+	precise, err := precisePool.GetQuotePrecise(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := referenceOut(a, b, params.InAmount, LifinityFeeRate)
+	if precise.OutAmount != want {
+		t.Errorf("precise out = %d, want %d", precise.OutAmount, want)
+	}
+	if precisePool.B != b-want {
+		t.Errorf("B = %d, want %d", precisePool.B, b-want)
+	}
+
+	// float64 K carries 53 bits, so the float path is off at this size
+	floatQuote, err := NewLifinityLiquidity(a, b).GetQuote(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if floatQuote.OutAmount == want {
+		t.Errorf("float out = %d matches the exact output; expected float64 rounding", floatQuote.OutAmount)
+	}
+}
+
+func TestKFollowsReserves(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	for _, params := range []QuoteParams{{InAmount: 10, AToB: true}, {InAmount: 500}} {
+		if _, err := pool.GetQuote(params); err != nil {
+			t.Fatal(err)
+		}
+		if want := float64(pool.A) * float64(pool.B); pool.K() != want {
+			t.Errorf("K = %v, want A*B = %v", pool.K(), want)
+		}
+	}
+}
+
+func TestPriceImpactAgainstPreSwapPrice(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	before := pool.Price(true)
+	q, err := pool.GetQuote(QuoteParams{InAmount: 10, AToB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := uint((pool.Price(true) - before) / before * 10_000)
+	if q.PriceImpactBP != want || q.PriceImpactBP < 100 {
+		t.Errorf("impact = %d bps, want %d", q.PriceImpactBP, want)
+	}
+}
+
+func TestSimulateQuoteIsPure(t *testing.T) {
+	pool := NewLifinityLiquidity(1000, 20_000)
+	params := QuoteParams{InAmount: 10, AToB: true}
+	first, err := pool.SimulateQuote(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.SimulateQuote(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("quotes differ: %+v, %+v", first, second)
+	}
+	if pool.A != 1000 || pool.B != 20_000 || pool.K() != 2e7 {
+		t.Errorf("reserves = %d/%d, want them unchanged", pool.A, pool.B)
+	}
+
+	executed, err := pool.GetQuote(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *executed != *first {
+		t.Errorf("executed quote = %+v, want the simulated %+v", executed, first)
+	}
+}
+
+func TestGetQuoteContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pool := NewLifinityLiquidity(1000, 20_000)
+	if _, err := pool.GetQuoteContext(ctx, QuoteParams{InAmount: 10, AToB: true}); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if pool.A != 1000 || pool.B != 20_000 {
+		t.Error("a canceled quote moved the reserves")
+	}
+}
@@ -0,0 +1,2062 @@
+package phoenix
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/marccanlas/phoenix-sdk-migration/venue"
+)
+
+type MarketState struct{}
+
+type ClockData struct {
+	Slot          int64
+	UnixTimestamp int64
+}
+
+type LadderLevel struct {
+	PriceInTicks   float64
+	SizeInBaseLots float64
+}
+
+type UiLadderLevel struct {
+	Price      float64      `json:"price"`
+	Quantity   float64      `json:"quantity"`
+	QueueAhead float64      `json:"queueAhead,omitempty"` // Displayed quantity queued ahead of our order; optional, never filled
+	Denom      Denomination `json:"denom,omitempty"`      // Unit of Quantity and QueueAhead; base by default
+	// Latest slot at which an order on the level was valid; zero when unknown
+	LastValidSlot int64 `json:"lastValidSlot,omitempty"`
+}
+
+// Denomination is the unit a level's quantities are expressed in.
+type Denomination int
+
+const (
+	DenomBase  Denomination = iota // Quantities are base units
+	DenomQuote                     // Quantities are quote notional, converted to base at the level price
+)
+
+// available returns the part of the displayed quantity a sweep can actually
+// fill, in base units.
+func (l UiLadderLevel) available() float64 {
+	quantity := l.Quantity
+	if l.QueueAhead > 0 {
+		quantity = math.Max(l.Quantity-l.QueueAhead, 0)
+	}
+	if l.Denom == DenomQuote {
+		return quantity / l.Price
+	}
+	return quantity
+}
+
+// drain removes base units of fills from the level's displayed quantity.
+func (l *UiLadderLevel) drain(base float64) {
+	if l.Denom == DenomQuote {
+		l.Quantity -= base * l.Price
+		return
+	}
+	l.Quantity -= base
+}
+
+type UiLadder struct {
+	Asks []UiLadderLevel `json:"asks"`
+	Bids []UiLadderLevel `json:"bids"`
+	// Bumped every time GetQuote or ApplyFraction drains the ladder; whoever
+	// replaces levels from an on-chain update must bump it too. QuoteCache uses
+	// it to tell whether its quotes are still current.
+	LadderVersion uint64 `json:"ladderVersion"`
+}
+
+// clone returns a copy of the ladder whose levels can be drained independently.
+func (l *UiLadder) clone() *UiLadder {
+	return &UiLadder{
+		Bids:          append([]UiLadderLevel(nil), l.Bids...),
+		Asks:          append([]UiLadderLevel(nil), l.Asks...),
+		LadderVersion: l.LadderVersion,
+	}
+}
+
+type Hoenix struct {
+	MarketStates map[string]MarketState
+	Clock        ClockData
+	FeeTier      FeeTier   `json:"-"` // Size-dependent taker fee; the flat Data fees are used when nil
+	MinNotional  float64   // Smallest input, in quote units, GetQuote accepts; zero disables the check
+	Ladder       *UiLadder // Book quoted by Quote, the venue.Quoter adapter; GetQuote takes its own
+	BaseLotSize  float64   // Base atoms per base lot; derived from the header when zero, see baseLotSize
+	QuoteLotSize float64   // Quote atoms per quote lot; one atom when zero
+	// Slots the freshest consumable level may lag Clock.Slot before GetQuote
+	// returns ErrBookStale; zero disables the check
+	MaxBookAgeSlots int64
+	// Share of the output GetQuote withholds, in [0, 1), given the fill's base
+	// size over the base depth of the swept side; nil disables the haircut
+	ThinBookHaircut func(depthRatio float64) float64 `json:"-"`
+	Logger          Logger                           `json:"-"` // Sweep diagnostics; nothing is printed when nil
+	// Execution-risk haircut, in bps of price, applied by LatencyAdjustedPrice
+	LatencyPenaltyBps float64
+	Data              struct {
+		Bids   map[string]RawOrder
+		Asks   map[string]RawOrder
+		Header struct {
+			BaseParams              struct{ Decimals int }
+			QuoteParams             struct{ Decimals int }
+			RawBaseUnitsPerBaseUnit float64
+		}
+		TakerFeeBps float64
+		BuyFeeBps   float64 // Taker fee for buys; TakerFeeBps is used when zero
+		SellFeeBps  float64 // Taker fee for sells; TakerFeeBps is used when zero
+	}
+}
+
+// RawOrder is a resting order as read from the market account, in ticks and
+// base lots. BuildUiLadder turns these into UiLadderLevels.
+type RawOrder struct {
+	LastValidSlot                   int64
+	LastValidUnixTimestampInSeconds int64
+	NumBaseLots                     float64
+	PriceInTicks                    float64
+}
+
+// Logger receives optional diagnostics from the quote path. *log.Logger
+// satisfies it.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+func (h *Hoenix) logf(format string, args ...any) {
+	if h.Logger != nil {
+		h.Logger.Printf(format, args...)
+	}
+}
+
+// Snapshot serializes the whole market, including MarketStates, Clock and the
+// nested Data structs, as indented JSON for golden-snapshot tests. FeeTier is
+// behavior rather than state and is not included.
+func (h *Hoenix) Snapshot() ([]byte, error) {
+	return json.MarshalIndent(h, "", "  ")
+}
+
+// LoadSnapshot restores a market serialized with Snapshot.
+func LoadSnapshot(data []byte) (*Hoenix, error) {
+	var h Hoenix
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("load snapshot: %w", err)
+	}
+	return &h, nil
+}
+
+const (
+	FeeScale = 10_000
+	// MaxRebateBps is the largest rebate, charged as a negative taker fee, that
+	// Quote.Validate accepts.
+	MaxRebateBps = 100
+)
+
+// FeeTier models an account's size-dependent taker fee schedule, e.g. rebates
+// on small trades. size is the trade's notional in quote units whichever way it
+// goes: a buy's quote input, or a sell's base input valued at the best bid.
+type FeeTier interface {
+	TakerFeeBpsForSize(size float64) float64
+}
+
+// FeeTierFunc adapts a plain function to FeeTier.
+type FeeTierFunc func(size float64) float64
+
+func (f FeeTierFunc) TakerFeeBpsForSize(size float64) float64 {
+	return f(size)
+}
+
+// takerFeeBps returns the taker fee for a trade on side whose notional is size
+// quote units. A FeeTier takes precedence over the direction-specific fees,
+// which in turn fall back to TakerFeeBps.
+func (h *Hoenix) takerFeeBps(side Side, size float64) float64 {
+	if h.FeeTier != nil {
+		return h.FeeTier.TakerFeeBpsForSize(size)
+	}
+	if side == Bid && h.Data.BuyFeeBps != 0 {
+		return h.Data.BuyFeeBps
+	}
+	if side == Ask && h.Data.SellFeeBps != 0 {
+		return h.Data.SellFeeBps
+	}
+	return h.Data.TakerFeeBps
+}
+
+type Side int
+
+const (
+	Bid Side = iota
+	Ask
+)
+
+type QuoteParams struct {
+	InAmount       float64
+	AToB           bool
+	AbsoluteMinOut float64 // Reject quotes paying out less than this; zero disables the floor
+	FillOrKill     bool    // Fill the whole size or nothing; the ladder is left untouched on failure
+	// Stop the sweep after filling this many distinct prices and return a partial
+	// fill; zero means no limit. Adjacent levels at the same price count once.
+	// With FillOrKill a sweep stopped short fails with ErrFillOrKillUnmet.
+	MaxDistinctLevels int
+	// Prices of our own resting orders; levels within priceEpsilon of any of them
+	// are skipped by the sweep and left untouched
+	ExcludePrices []float64
+	// Integrator fee charged per trade in quote units on top of the bps fee. It
+	// comes off the quote leg: out of the input for buys, out of the output for sells.
+	FlatFee float64
+	// Slippage bound on OutAmount, checked after AbsoluteMinOut and reported as a
+	// *SlippageError; zero means no limit
+	MinOutAmount float64
+	ValidateBook bool // Run UiLadder.Validate before quoting and reject unsorted or crossed books
+	// Fill as much as the book holds instead of failing with
+	// ErrInsufficientLiquidity; the quote is then marked Partial
+	AllowPartial bool
+}
+
+// ErrBelowFloor is returned when a quote pays out less than QuoteParams.AbsoluteMinOut.
+var ErrBelowFloor = errors.New("quote output is below the absolute minimum")
+
+// ErrSlippageExceeded matches, via errors.Is, the *SlippageError returned when
+// a quote pays out less than QuoteParams.MinOutAmount.
+var ErrSlippageExceeded = errors.New("slippage exceeded")
+
+// SlippageError carries the output a quote computed and the minimum the caller
+// required.
+type SlippageError struct {
+	OutAmount    float64
+	MinOutAmount float64
+}
+
+func (e *SlippageError) Error() string {
+	return fmt.Sprintf("%v: got %v, minimum %v", ErrSlippageExceeded, e.OutAmount, e.MinOutAmount)
+}
+
+func (e *SlippageError) Unwrap() error {
+	return ErrSlippageExceeded
+}
+
+// ErrInsufficientLiquidity is returned when the book cannot fill the requested
+// size.
+var ErrInsufficientLiquidity = errors.New("not enough liquidity to fulfill the trade")
+
+// ErrBookStale is returned when every consumable level on the swept side was
+// last valid more than Hoenix.MaxBookAgeSlots before the clock.
+var ErrBookStale = errors.New("order book is stale")
+
+// ErrBelowMinNotional is returned when an order's quote value is under Hoenix.MinNotional.
+var ErrBelowMinNotional = errors.New("order notional is below the minimum")
+
+// ErrFillOrKillUnmet is returned when a FillOrKill quote cannot fill its full size.
+var ErrFillOrKillUnmet = errors.New("fill-or-kill order cannot be filled in full")
+
+type Quote struct {
+	InAmount   float64 `json:"inAmount"`
+	OutAmount  float64 `json:"outAmount"`
+	AToB       bool    `json:"aToB"`
+	PremiumPct float64 `json:"premiumPct"` // Realized price vs. top of book in percent; positive means worse than best
+	FeeBps     float64 `json:"feeBps"`     // Taker fee applied to this quote
+	// Taker fee charged, in quote units: on top of the spent input for buys, out
+	// of the proceeds for sells. FlatFee is not included.
+	FeePaid float64 `json:"feePaid"`
+	// Distance in basis points between the fill's VWAP, before fees, and the best
+	// price on the swept side; zero when the fill stays within the top level
+	PriceImpactBP uint    `json:"priceImpactBp"`
+	FlatFee       float64 `json:"flatFee"` // Flat per-trade fee in quote units, included in InAmount or deducted from OutAmount
+	Partial       bool    `json:"partial"` // The sweep stopped at a level limit or ran out of book; InAmount is the input actually spent
+	// Input filled and input requested; they differ only on partial quotes, where
+	// Filled equals InAmount and Requested is QuoteParams.InAmount
+	Filled    float64 `json:"filled"`
+	Requested float64 `json:"requested"`
+	// Indices into the swept side (asks for buys, bids for sells) of the levels
+	// the fill emptied, and of the level it stopped inside, if any
+	FullyConsumed     []int `json:"fullyConsumed"`
+	PartiallyConsumed *int  `json:"partiallyConsumed"`
+	// Per-level breakdown of the sweep, before fees; for buys without a haircut
+	// the fills sum to OutAmount
+	Fills []Fill `json:"fills"`
+}
+
+// Fill is what a quote took from one ladder level.
+type Fill struct {
+	Level       int     `json:"level"` // Index of the level in the swept side
+	Price       float64 `json:"price"`
+	BaseFilled  float64 `json:"baseFilled"`
+	QuoteFilled float64 `json:"quoteFilled"`
+}
+
+// AverageFillPrice returns the quote's effective price in quote per base, fees
+// included: InAmount/OutAmount for buys and OutAmount/InAmount for sells.
+func (q *Quote) AverageFillPrice() (float64, error) {
+	if q.InAmount <= 0 || q.OutAmount <= 0 {
+		return 0, fmt.Errorf("no fill to price: in %v, out %v", q.InAmount, q.OutAmount)
+	}
+	if q.AToB {
+		return q.InAmount / q.OutAmount, nil
+	}
+	return q.OutAmount / q.InAmount, nil
+}
+
+// Validate checks that the quote is internally consistent before acting on it:
+// positive finite amounts, a fee rate below 100% and no deeper a rebate than
+// MaxRebateBps, a taker fee no larger than the quote leg it was charged on, and
+// a finite premium over top of book no lower than the rebate. A sell cannot
+// fill more than 100% below the best bid; a buy sweeping a steep book can pay
+// any multiple of the best ask, so its premium has no upper bound. When Fills are present, the quote's average price before fees must also lie
+// between its best and worst fill prices.
+func (q *Quote) Validate() error {
+	if !(q.InAmount > 0) || math.IsInf(q.InAmount, 0) {
+		return fmt.Errorf("invalid input amount %v", q.InAmount)
+	}
+	if !(q.OutAmount > 0) || math.IsInf(q.OutAmount, 0) {
+		return fmt.Errorf("invalid output amount %v", q.OutAmount)
+	}
+	if !(q.FeeBps >= -MaxRebateBps) || q.FeeBps >= FeeScale {
+		return fmt.Errorf("invalid fee rate %v bps", q.FeeBps)
+	}
+	// A rebate can fill below the best price by up to its own rate. Allow for
+	// float noise on fills entirely at the best level.
+	minPremium := math.Min(q.FeeBps, 0) / FeeScale * 100
+	if math.IsNaN(q.PremiumPct) || math.IsInf(q.PremiumPct, 0) || q.PremiumPct < minPremium-1e-9 || (!q.AToB && q.PremiumPct > 100) {
+		return fmt.Errorf("premium over top of book %v%% is out of range", q.PremiumPct)
+	}
+
+	// Buys pay the fee on top of their quote input; sells out of the proceeds
+	var baseFilled, quoteFilled float64
+	for _, fill := range q.Fills {
+		baseFilled += fill.BaseFilled
+		quoteFilled += fill.QuoteFilled
+	}
+	quoteLeg := q.InAmount
+	if !q.AToB {
+		quoteLeg = quoteFilled
+	}
+	if math.IsNaN(q.FeePaid) || (quoteLeg > 0 && math.Abs(q.FeePaid) > quoteLeg) {
+		return fmt.Errorf("fee paid %v exceeds the quote amount %v", q.FeePaid, quoteLeg)
+	}
+
+	if len(q.Fills) == 0 || !(baseFilled > 0) {
+		return nil
+	}
+	low, high := q.Fills[0].Price, q.Fills[0].Price
+	for _, fill := range q.Fills[1:] {
+		low, high = math.Min(low, fill.Price), math.Max(high, fill.Price)
+	}
+	// Priced from the side of the quote a thin book haircut leaves alone
+	avgPrice := quoteFilled / q.InAmount
+	if q.AToB {
+		avgPrice = (q.InAmount - q.FeePaid - q.FlatFee) / baseFilled
+	}
+	if tolerance := 1e-9 * high; avgPrice < low-tolerance || avgPrice > high+tolerance {
+		return fmt.Errorf("average price %v is outside the fill prices %v to %v", avgPrice, low, high)
+	}
+	return nil
+}
+
+// Format renders InAmount and OutAmount as plain decimal strings rounded to
+// the decimals of their token, without trailing zeros. Buys (AToB) spend quote
+// and receive base; sells the reverse.
+func (q *Quote) Format(baseDecimals, quoteDecimals int) (inStr, outStr string) {
+	if q.AToB {
+		return formatDecimal(q.InAmount, quoteDecimals), formatDecimal(q.OutAmount, baseDecimals)
+	}
+	return formatDecimal(q.InAmount, baseDecimals), formatDecimal(q.OutAmount, quoteDecimals)
+}
+
+func formatDecimal(v float64, decimals int) string {
+	str := strconv.FormatFloat(v, 'f', max(decimals, 0), 64)
+	if strings.Contains(str, ".") {
+		str = strings.TrimRight(strings.TrimRight(str, "0"), ".")
+	}
+	if str == "-0" {
+		return "0"
+	}
+	return str
+}
+
+// HashPrecision is the number of decimal places numeric fields are rounded to
+// before hashing.
+const HashPrecision = 9
+
+// Hash returns a stable FNV-1a digest of every field of the quote, with float
+// fields rounded to HashPrecision decimals, so logically equal quotes hash
+// identically across machines.
+func (q *Quote) Hash() string {
+	h := fnv.New64a()
+	var buf [8]byte
+	scale := math.Pow10(HashPrecision)
+	writeUint := func(v uint64) {
+		binary.BigEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	writeFloat := func(v float64) {
+		// Hash the bits of the rounded float: converting to an integer count of
+		// 1e-9 units would overflow int64 above about 9.2e9
+		rounded := math.Round(v*scale) / scale
+		if rounded == 0 {
+			rounded = 0 // Fold -0 into 0
+		}
+		writeUint(math.Float64bits(rounded))
+	}
+	writeBool := func(b bool) {
+		if b {
+			h.Write([]byte{1})
+		} else {
+			h.Write([]byte{0})
+		}
+	}
+
+	for _, v := range []float64{q.InAmount, q.OutAmount, q.PremiumPct, q.FeeBps, q.FeePaid, q.FlatFee, q.Filled, q.Requested} {
+		writeFloat(v)
+	}
+	writeUint(uint64(q.PriceImpactBP))
+	writeBool(q.AToB)
+	writeBool(q.Partial)
+	// Slices are length-prefixed so moving an element between them changes the
+	// digest
+	writeUint(uint64(len(q.FullyConsumed)))
+	for _, i := range q.FullyConsumed {
+		writeUint(uint64(i))
+	}
+	writeBool(q.PartiallyConsumed != nil)
+	if q.PartiallyConsumed != nil {
+		writeUint(uint64(*q.PartiallyConsumed))
+	}
+	writeUint(uint64(len(q.Fills)))
+	for _, f := range q.Fills {
+		writeUint(uint64(f.Level))
+		writeFloat(f.Price)
+		writeFloat(f.BaseFilled)
+		writeFloat(f.QuoteFilled)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// GetQuote now returns the updated ladder instead of liquidity
+func (h *Hoenix) GetQuote(params QuoteParams, ladder *UiLadder) (*Quote, *UiLadder, error) {
+	return h.GetQuoteContext(context.Background(), params, ladder)
+}
+
+// GetQuoteContext is GetQuote bounded by ctx: the sweep checks ctx before it
+// starts and at every level, and returns ctx.Err() as soon as ctx is done. The
+// ladder is left untouched by a canceled quote.
+func (h *Hoenix) GetQuoteContext(ctx context.Context, params QuoteParams, ladder *UiLadder) (*Quote, *UiLadder, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	side := Bid
+	if !params.AToB {
+		side = Ask
+	}
+	if err := checkQuotableLadder(ladder); err != nil {
+		return nil, nil, err
+	}
+	if params.ValidateBook {
+		if err := ladder.Validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := h.checkMinNotional(ladder, params); err != nil {
+		return nil, nil, err
+	}
+	if err := h.checkBookAge(ladder, side, params); err != nil {
+		return nil, nil, err
+	}
+
+	if params.FlatFee < 0 {
+		return nil, nil, errors.New("flat fee must not be negative")
+	}
+	if params.FillOrKill && params.AllowPartial {
+		return nil, nil, errors.New("fill-or-kill and partial fills are mutually exclusive")
+	}
+	sweepIn := params.InAmount
+	if params.AToB {
+		sweepIn -= params.FlatFee
+		if sweepIn <= 0 {
+			return nil, nil, errors.New("flat fee exceeds the input amount")
+		}
+	}
+
+	notional, _ := quoteNotional(ladder, params.InAmount, params.AToB)
+	feeBps := h.takerFeeBps(side, notional)
+	opts := sweepOptions{
+		maxDistinctLevels: params.MaxDistinctLevels,
+		excludePrices:     params.ExcludePrices,
+		allowPartial:      params.AllowPartial,
+		ctx:               ctx,
+	}
+	result, err := h.getExpectedOutAmount(ladder, side, feeBps, sweepIn, opts)
+	if err != nil {
+		if errors.Is(err, ErrInsufficientLiquidity) {
+			if params.FillOrKill {
+				return nil, nil, ErrFillOrKillUnmet
+			}
+			return nil, nil, fmt.Errorf("%w for the requested amount %v", ErrInsufficientLiquidity, params.InAmount)
+		}
+		return nil, nil, err
+	}
+	// A level limit stops the sweep short without an error; fill-or-kill must
+	// still reject the order before anything is drained
+	if params.FillOrKill && result.remaining > 0 {
+		return nil, nil, ErrFillOrKillUnmet
+	}
+	expectedOutAmount := result.amount
+
+	// A sweep stopped at a level limit or at the end of the book leaves part of
+	// the input unspent
+	inAmount := params.InAmount
+	if result.remaining > 0 && params.AToB {
+		inAmount -= result.remaining * (1 + feeBps/FeeScale)
+	} else if result.remaining > 0 {
+		inAmount -= result.remaining
+	}
+
+	// A sell's flat fee comes out of the proceeds; the bids are still drained by
+	// the full base sold below
+	if !params.AToB {
+		expectedOutAmount -= params.FlatFee
+		if expectedOutAmount <= 0 {
+			return nil, nil, errors.New("flat fee exceeds the output amount")
+		}
+	}
+
+	// What the sweep itself spent and returned, fees excluded
+	spent, swept := sweepIn-result.remaining, result.amount+result.feePaid
+	if params.AToB {
+		spent, swept = h.applyTakerFee(sweepIn, feeBps)-result.remaining, result.amount
+	}
+	if h.ThinBookHaircut != nil {
+		if expectedOutAmount, err = h.applyThinBookHaircut(ladder, side, spent, swept, expectedOutAmount); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if params.AbsoluteMinOut > 0 && expectedOutAmount < params.AbsoluteMinOut {
+		return nil, nil, fmt.Errorf("%w: got %v, floor %v", ErrBelowFloor, expectedOutAmount, params.AbsoluteMinOut)
+	}
+	if params.MinOutAmount > 0 && expectedOutAmount < params.MinOutAmount {
+		return nil, nil, &SlippageError{OutAmount: expectedOutAmount, MinOutAmount: params.MinOutAmount}
+	}
+
+	// Top of book has to be read before the ladder is drained below
+	premium := premiumPct(ladder, side, inAmount, expectedOutAmount)
+	impactBP := priceImpactBP(ladder, side, spent, swept)
+
+	// Instead of using liquidity, we will update the ladder directly. Each level
+	// loses exactly the base its fill took, for buys and sells alike, so the book
+	// matches the quote whatever fees or limits applied to the amounts.
+	drainFills(takerLevels(ladder, side), result.fills)
+	ladder.LadderVersion++
+
+	// Check if the ladder has sufficient liquidity
+	if len(ladder.Asks) == 0 || len(ladder.Bids) == 0 {
+		return nil, nil, errors.New("updated ladder has no more asks or bids")
+	}
+
+	// Return the Quote and updated ladder instead of liquidity
+	return &Quote{
+		InAmount:      inAmount,
+		OutAmount:     expectedOutAmount,
+		AToB:          params.AToB,
+		PremiumPct:    premium,
+		PriceImpactBP: impactBP,
+		FeeBps:        feeBps,
+		FeePaid:       result.feePaid,
+		FlatFee:       params.FlatFee,
+		Partial:       result.remaining > 0,
+		Filled:        inAmount,
+		Requested:     params.InAmount,
+
+		FullyConsumed:     result.fullyConsumed,
+		PartiallyConsumed: result.partiallyConsumed,
+		Fills:             result.fills,
+	}, ladder, nil
+}
+
+// applyThinBookHaircut cuts out by ThinBookHaircut's share. spent and swept are
+// the sweep's input after fees and its output; depth is read before the ladder
+// is drained.
+func (h *Hoenix) applyThinBookHaircut(ladder *UiLadder, side Side, spent, swept, out float64) (float64, error) {
+	depth := 0.0
+	for _, level := range takerLevels(ladder, side) {
+		depth += level.available()
+	}
+
+	size := swept // Base bought
+	if side == Ask {
+		size = spent // Base sold
+	}
+	haircut := h.ThinBookHaircut(size / depth)
+	if haircut < 0 || haircut >= 1 || math.IsNaN(haircut) {
+		return 0, fmt.Errorf("thin book haircut %v must be in [0, 1)", haircut)
+	}
+	return out * (1 - haircut), nil
+}
+
+// QuoteAssumingTopStale prices params as if the first staleLevels levels on the
+// swept side (asks for buys, bids for sells) were gone by execution time. The
+// sweep runs on a copy, so ladder is left untouched; the quote's level indices
+// still refer to ladder.
+func (h *Hoenix) QuoteAssumingTopStale(ladder *UiLadder, params QuoteParams, staleLevels int) (*Quote, error) {
+	if staleLevels < 0 {
+		return nil, errors.New("stale level count must not be negative")
+	}
+
+	trimmed := ladder.clone()
+	if params.AToB {
+		trimmed.Asks = trimmed.Asks[min(staleLevels, len(trimmed.Asks)):]
+	} else {
+		trimmed.Bids = trimmed.Bids[min(staleLevels, len(trimmed.Bids)):]
+	}
+
+	quote, _, err := h.GetQuote(params, trimmed)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range quote.FullyConsumed {
+		quote.FullyConsumed[i] += staleLevels
+	}
+	if quote.PartiallyConsumed != nil {
+		index := *quote.PartiallyConsumed + staleLevels
+		quote.PartiallyConsumed = &index
+	}
+	for i := range quote.Fills {
+		quote.Fills[i].Level += staleLevels
+	}
+	return quote, nil
+}
+
+// GetQuoteReadOnly prices params like GetQuote but sweeps a copy of ladder, so
+// the caller's book is left as is and repeated calls give identical quotes.
+func (h *Hoenix) GetQuoteReadOnly(params QuoteParams, ladder *UiLadder) (*Quote, error) {
+	quote, _, err := h.GetQuote(params, ladder.clone())
+	return quote, err
+}
+
+// QuoteCache memoizes read-only quotes against one ladder between updates. A
+// repeat request for the same input and direction is served without walking
+// the book for as long as the ladder's LadderVersion is unchanged; any bump
+// discards every cached quote. Quotes also depend on the market's fee settings,
+// so a cache should not outlive changes to them. Cached quotes are shared and
+// must not be modified. A QuoteCache is not safe for concurrent use.
+type QuoteCache struct {
+	hoenix  *Hoenix
+	ladder  *UiLadder
+	version uint64
+	quotes  map[quoteCacheKey]*Quote
+}
+
+type quoteCacheKey struct {
+	version  uint64
+	inAmount float64
+	aToB     bool
+}
+
+// NewQuoteCache returns an empty cache of h's quotes against ladder.
+func NewQuoteCache(h *Hoenix, ladder *UiLadder) *QuoteCache {
+	return &QuoteCache{hoenix: h, ladder: ladder, version: ladder.LadderVersion, quotes: map[quoteCacheKey]*Quote{}}
+}
+
+// Quote returns GetQuoteReadOnly's quote for inAmount in the direction aToB,
+// from the cache when the ladder has not changed since it was computed. Errors
+// are not cached.
+func (c *QuoteCache) Quote(inAmount float64, aToB bool) (*Quote, error) {
+	if c.ladder.LadderVersion != c.version {
+		c.quotes = map[quoteCacheKey]*Quote{}
+		c.version = c.ladder.LadderVersion
+	}
+	key := quoteCacheKey{version: c.version, inAmount: inAmount, aToB: aToB}
+	if quote, ok := c.quotes[key]; ok {
+		return quote, nil
+	}
+
+	quote, err := c.hoenix.GetQuoteReadOnly(QuoteParams{InAmount: inAmount, AToB: aToB}, c.ladder)
+	if err != nil {
+		return nil, err
+	}
+	c.quotes[key] = quote
+	return quote, nil
+}
+
+// QuoteReceipt records everything a quote was computed from, so it can be
+// serialized with encoding/json and replayed later with ReplayQuote.
+type QuoteReceipt struct {
+	Params QuoteParams
+	Market json.RawMessage // Snapshot of the market, clock and fees included
+	Ladder UiLadder        // The book as it was before the quote drained it
+	Quote  Quote
+}
+
+// ErrReceiptMismatch is returned by ReplayQuote when the recomputed quote
+// differs from the recorded one.
+var ErrReceiptMismatch = errors.New("replayed quote does not match the receipt")
+
+// GetQuoteWithReceipt is GetQuote that also returns a receipt of the quote.
+func (h *Hoenix) GetQuoteWithReceipt(params QuoteParams, ladder *UiLadder) (*Quote, *UiLadder, *QuoteReceipt, error) {
+	market, err := h.Snapshot()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	before := ladder.clone()
+
+	quote, ladder, err := h.GetQuote(params, ladder)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return quote, ladder, &QuoteReceipt{Params: params, Market: market, Ladder: *before, Quote: *quote}, nil
+}
+
+// ReplayQuote recomputes a receipt's quote against its recorded market and
+// ladder and returns ErrReceiptMismatch unless it hashes like the recorded one.
+// FeeTier and ThinBookHaircut are behavior and are not recorded: the replay
+// charges the recorded FeeBps and applies no haircut.
+func ReplayQuote(r QuoteReceipt) (*Quote, error) {
+	market, err := LoadSnapshot(r.Market)
+	if err != nil {
+		return nil, err
+	}
+	recordedFee := r.Quote.FeeBps
+	market.FeeTier = FeeTierFunc(func(float64) float64 { return recordedFee })
+
+	quote, err := market.GetQuoteReadOnly(r.Params, &r.Ladder)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	if quote.Hash() != r.Quote.Hash() {
+		return quote, fmt.Errorf("%w: got %+v, recorded %+v", ErrReceiptMismatch, *quote, r.Quote)
+	}
+	return quote, nil
+}
+
+// maxTierLookups bounds the search for a buy's fee in GetQuoteExactOut. A
+// schedule whose tiers keep moving the gross input across a boundary has no
+// consistent fee; the last one looked up is used.
+const maxTierLookups = 8
+
+// GetQuoteExactOut is the inverse of GetQuote: it returns the input needed to
+// receive exactly outAmount, base for buys (aToB) and quote for sells. The
+// taker fee stays on the quote leg: a buy's input is grossed up for it and a
+// sell sweeps enough extra proceeds to cover it, so feeding InAmount back to
+// GetQuote yields outAmount. The ladder is not modified.
+func (h *Hoenix) GetQuoteExactOut(outAmount float64, aToB bool, ladder *UiLadder) (*Quote, error) {
+	if outAmount <= 0 {
+		return nil, errors.New("output amount must be greater than zero")
+	}
+
+	side := Bid
+	if !aToB {
+		side = Ask
+	}
+	cursor := sweepCursor{levels: takerLevels(ladder, side)}
+
+	if aToB {
+		filled, netIn, _ := cursor.fillBase(outAmount)
+		if filled < outAmount*(1-priceEpsilon) {
+			return nil, ErrInsufficientLiquidity
+		}
+
+		// GetQuote looks the tier up with the gross input, which itself depends
+		// on the fee; settle on a fee that the gross it implies maps back to
+		feeBps := h.takerFeeBps(side, netIn)
+		for i := 0; i < maxTierLookups; i++ {
+			next := h.takerFeeBps(side, netIn*(1+feeBps/FeeScale))
+			if next == feeBps {
+				break
+			}
+			feeBps = next
+		}
+		inAmount := netIn * (1 + feeBps/FeeScale)
+		return &Quote{
+			InAmount:      inAmount,
+			OutAmount:     outAmount,
+			AToB:          aToB,
+			PremiumPct:    premiumPct(ladder, side, inAmount, outAmount),
+			PriceImpactBP: priceImpactBP(ladder, side, netIn, outAmount),
+			FeeBps:        feeBps,
+			FeePaid:       inAmount - netIn,
+		}, nil
+	}
+
+	// A sell's fee comes out of the proceeds, so the book has to yield
+	// outAmount grossed up; the tier is looked up with the notional of the base
+	// the net amount alone would take
+	estimate, _ := (&sweepCursor{levels: cursor.levels}).fillQuote(outAmount)
+	notional, _ := quoteNotional(ladder, estimate, false)
+	feeBps := h.takerFeeBps(side, notional)
+	gross := outAmount / (1 - feeBps/FeeScale)
+	inAmount, filled := cursor.fillQuote(gross)
+	if filled < gross*(1-priceEpsilon) {
+		return nil, ErrInsufficientLiquidity
+	}
+	return &Quote{
+		InAmount:      inAmount,
+		OutAmount:     outAmount,
+		AToB:          aToB,
+		PremiumPct:    premiumPct(ladder, side, inAmount, outAmount),
+		PriceImpactBP: priceImpactBP(ladder, side, inAmount, gross),
+		FeeBps:        feeBps,
+		FeePaid:       gross - outAmount,
+	}, nil
+}
+
+var _ venue.Quoter = (*Hoenix)(nil)
+
+// Quote implements venue.Quoter by pricing in against a copy of h.Ladder.
+// venue.Quoter's aToB sells base, the opposite of QuoteParams.AToB, which buys it.
+// The output is an Amount in the decimals of the token paid out, from the header.
+func (h *Hoenix) Quote(in venue.Amount, aToB bool) (venue.Quote, error) {
+	if h.Ladder == nil {
+		return venue.Quote{}, errors.New("no ladder to quote against")
+	}
+
+	quote, err := h.GetQuoteReadOnly(QuoteParams{InAmount: in.ToFloat(), AToB: !aToB}, h.Ladder)
+	if err != nil {
+		return venue.Quote{}, err
+	}
+	// Selling base pays out quote; buying pays out base
+	convert := h.BaseAmount
+	if aToB {
+		convert = h.QuoteAmount
+	}
+	out, err := convert(quote.OutAmount)
+	if err != nil {
+		return venue.Quote{}, err
+	}
+	return venue.Quote{
+		InAmount:      in,
+		OutAmount:     out,
+		AToB:          aToB,
+		FeeBps:        quote.FeeBps,
+		PriceImpactBP: quote.PriceImpactBP,
+	}, nil
+}
+
+// quoteNotional values an input of amount in quote units: a buy's quote input
+// as is, and a sell's base at the best bid. ok is false when a sell meets an
+// empty bid side.
+func quoteNotional(ladder *UiLadder, amount float64, aToB bool) (notional float64, ok bool) {
+	if aToB {
+		return amount, true
+	}
+	bestBid, ok := bestLevelPrice(ladder.Bids)
+	return amount * bestBid, ok
+}
+
+// checkMinNotional rejects orders whose input is worth less than MinNotional
+// in quote units. Buys spend quote directly; sells are valued at the best bid.
+func (h *Hoenix) checkMinNotional(ladder *UiLadder, params QuoteParams) error {
+	if h.MinNotional <= 0 {
+		return nil
+	}
+
+	notional, ok := quoteNotional(ladder, params.InAmount, params.AToB)
+	if !ok {
+		return nil // The sweep reports the empty book
+	}
+	if notional < h.MinNotional {
+		return fmt.Errorf("%w: %v < %v", ErrBelowMinNotional, notional, h.MinNotional)
+	}
+	return nil
+}
+
+// checkBookAge rejects books whose freshest consumable level on the swept side
+// is older than MaxBookAgeSlots. Levels without a LastValidSlot are not
+// considered; if none has one, the book passes.
+func (h *Hoenix) checkBookAge(ladder *UiLadder, side Side, params QuoteParams) error {
+	if h.MaxBookAgeSlots <= 0 {
+		return nil
+	}
+
+	opts := sweepOptions{excludePrices: params.ExcludePrices}
+	freshest := int64(0)
+	for _, level := range takerLevels(ladder, side) {
+		if level.available() > 0 && !opts.excluded(level.Price) && level.LastValidSlot > freshest {
+			freshest = level.LastValidSlot
+		}
+	}
+	if freshest == 0 {
+		return nil
+	}
+	if age := h.Clock.Slot - freshest; age > h.MaxBookAgeSlots {
+		return fmt.Errorf("%w: freshest level is %d slots old, limit %d", ErrBookStale, age, h.MaxBookAgeSlots)
+	}
+	return nil
+}
+
+// GetQuoteMulti quotes against the union of several typed ladders (e.g. limit,
+// post-only and immediate liquidity) and drains each source ladder by what was
+// filled from it.
+//
+// Merge rule: levels are ordered by price first, best price first on each
+// side, so price priority is never violated. Levels at the same price are
+// ordered by their ladder's priority, lower values first, and then by their
+// position in the input. Nil ladders are skipped. The returned ladder is the merged book after the
+// sweep; the quote's level indices refer to it.
+func (h *Hoenix) GetQuoteMulti(params QuoteParams, ladders []*UiLadder, priorities []int) (*Quote, *UiLadder, error) {
+	if len(ladders) != len(priorities) {
+		return nil, nil, errors.New("each ladder needs exactly one priority")
+	}
+
+	bids := mergeLevels(ladders, priorities, func(l *UiLadder) []UiLadderLevel { return l.Bids }, true)
+	asks := mergeLevels(ladders, priorities, func(l *UiLadder) []UiLadderLevel { return l.Asks }, false)
+	merged := &UiLadder{Bids: make([]UiLadderLevel, len(bids)), Asks: make([]UiLadderLevel, len(asks))}
+	for i, level := range bids {
+		merged.Bids[i] = *level
+	}
+	for i, level := range asks {
+		merged.Asks[i] = *level
+	}
+
+	quote, merged, err := h.GetQuote(params, merged)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, level := range bids {
+		level.Quantity = merged.Bids[i].Quantity
+	}
+	for i, level := range asks {
+		level.Quantity = merged.Asks[i].Quantity
+	}
+	for _, ladder := range ladders {
+		if ladder != nil {
+			ladder.LadderVersion++
+		}
+	}
+	return quote, merged, nil
+}
+
+// mergeLevels returns pointers to one side of every ladder's levels, ordered
+// by price (descending for bids), then priority, then input position.
+func mergeLevels(ladders []*UiLadder, priorities []int, side func(*UiLadder) []UiLadderLevel, descending bool) []*UiLadderLevel {
+	type prioritized struct {
+		level    *UiLadderLevel
+		priority int
+	}
+
+	var levels []prioritized
+	for i, ladder := range ladders {
+		if ladder == nil {
+			continue
+		}
+		sideLevels := side(ladder)
+		for j := range sideLevels {
+			levels = append(levels, prioritized{level: &sideLevels[j], priority: priorities[i]})
+		}
+	}
+
+	sort.SliceStable(levels, func(i, j int) bool {
+		a, b := levels[i], levels[j]
+		if a.level.Price != b.level.Price {
+			return (a.level.Price > b.level.Price) == descending
+		}
+		return a.priority < b.priority
+	})
+
+	merged := make([]*UiLadderLevel, len(levels))
+	for i, level := range levels {
+		merged[i] = level.level
+	}
+	return merged
+}
+
+// topLevel returns the first level that still has quantity.
+func topLevel(levels []UiLadderLevel) (UiLadderLevel, bool) {
+	for _, level := range levels {
+		if level.available() > 0 {
+			return level, true
+		}
+	}
+	return UiLadderLevel{}, false
+}
+
+// bestLevelPrice returns the price of the first level that still has quantity.
+func bestLevelPrice(levels []UiLadderLevel) (float64, bool) {
+	level, ok := topLevel(levels)
+	return level.Price, ok
+}
+
+// BestBid returns the price of the highest bid that still has quantity. Bids
+// are expected sorted best first; ok is false when the side is empty.
+func (l *UiLadder) BestBid() (float64, bool) {
+	return bestLevelPrice(l.Bids)
+}
+
+// BestAsk returns the price of the lowest ask that still has quantity.
+func (l *UiLadder) BestAsk() (float64, bool) {
+	return bestLevelPrice(l.Asks)
+}
+
+// MidPrice returns the midpoint between the best bid and ask; ok is false when
+// either side is empty.
+func (l *UiLadder) MidPrice() (float64, bool) {
+	bid, okBid := l.BestBid()
+	ask, okAsk := l.BestAsk()
+	if !okBid || !okAsk {
+		return 0, false
+	}
+	return (bid + ask) / 2, true
+}
+
+// SpreadBps returns the distance between the best ask and bid in basis points
+// of the mid. It is negative for a crossed book.
+func (l *UiLadder) SpreadBps() (float64, bool) {
+	mid, ok := l.MidPrice()
+	if !ok {
+		return 0, false
+	}
+	bid, _ := l.BestBid()
+	ask, _ := l.BestAsk()
+	return (ask - bid) / mid * FeeScale, true
+}
+
+// Microprice returns the top-of-book price weighted toward the side with less
+// size: (bestBid*askSize + bestAsk*bidSize) / (bidSize + askSize).
+func (l *UiLadder) Microprice() (float64, bool) {
+	bid, okBid := topLevel(l.Bids)
+	ask, okAsk := topLevel(l.Asks)
+	if !okBid || !okAsk {
+		return 0, false
+	}
+	bidSize, askSize := bid.available(), ask.available()
+	return (bid.Price*askSize + ask.Price*bidSize) / (bidSize + askSize), true
+}
+
+// Imbalance returns (bidDepth - askDepth) / (bidDepth + askDepth) over the top
+// n non-empty levels of each side, in [-1, 1]. Positive means more bids.
+func (l *UiLadder) Imbalance(n int) (float64, bool) {
+	if n <= 0 {
+		return 0, false
+	}
+	bidDepth, askDepth := topDepth(l.Bids, n), topDepth(l.Asks, n)
+	if bidDepth+askDepth == 0 {
+		return 0, false
+	}
+	return (bidDepth - askDepth) / (bidDepth + askDepth), true
+}
+
+func topDepth(levels []UiLadderLevel, n int) float64 {
+	depth := 0.0
+	for _, level := range levels {
+		if n == 0 {
+			break
+		}
+		if quantity := level.available(); quantity > 0 {
+			depth += quantity
+			n--
+		}
+	}
+	return depth
+}
+
+// TotalBidDepth returns the fillable base quantity across all bids and its
+// value in quote units at each level's price.
+func (l *UiLadder) TotalBidDepth() (base, quote float64) {
+	return totalDepth(l.Bids)
+}
+
+// TotalAskDepth is TotalBidDepth for the asks.
+func (l *UiLadder) TotalAskDepth() (base, quote float64) {
+	return totalDepth(l.Asks)
+}
+
+func totalDepth(levels []UiLadderLevel) (base, quote float64) {
+	for _, level := range levels {
+		if quantity := level.available(); quantity > 0 {
+			base += quantity
+			quote += quantity * level.Price
+		}
+	}
+	return base, quote
+}
+
+// Resilience returns the base size a taker on side has to sweep (the asks for
+// a Bid, the bids for an Ask, as in takerLevels) before the best price moves
+// targetBps away from where it is now: the depth at prices strictly inside the
+// band. Deeper books score higher.
+func (l *UiLadder) Resilience(side Side, targetBps float64) (size float64, err error) {
+	if targetBps <= 0 {
+		return 0, errors.New("target move must be greater than zero")
+	}
+
+	levels := takerLevels(l, side)
+	best, ok := bestLevelPrice(levels)
+	if !ok {
+		return 0, errors.New("no liquidity on the requested side")
+	}
+
+	for _, level := range levels {
+		if math.Abs(level.Price-best)/best*FeeScale >= targetBps {
+			break
+		}
+		size += level.available()
+	}
+	return size, nil
+}
+
+// ShapeStats describes the side of the book a taker on side sweeps (the asks
+// for a Bid, the bids for an Ask, as in takerLevels): the depth-weighted mean
+// price and standard deviation of price, and the largest single level's share
+// of the side's depth. A share near one flags a wall. All three are zero for an
+// empty side.
+func (l *UiLadder) ShapeStats(side Side) (meanPrice, stddev, maxLevelShare float64) {
+	levels := takerLevels(l, side)
+
+	depth, weighted, maxLevel := 0.0, 0.0, 0.0
+	for _, level := range levels {
+		quantity := level.available()
+		depth += quantity
+		weighted += quantity * level.Price
+		maxLevel = math.Max(maxLevel, quantity)
+	}
+	if depth == 0 {
+		return 0, 0, 0
+	}
+	meanPrice = weighted / depth
+
+	variance := 0.0
+	for _, level := range levels {
+		deviation := level.Price - meanPrice
+		variance += level.available() * deviation * deviation
+	}
+	return meanPrice, math.Sqrt(variance / depth), maxLevel / depth
+}
+
+// fairValueDepth is the number of levels per side FairValue reads imbalance from.
+const fairValueDepth = 5
+
+// FairValue leans the microprice toward the heavier side of the book:
+//
+//	fairValue = microprice + clamp(skewFactor * imbalance, -1, 1) * halfSpread
+//
+// where imbalance covers the top fairValueDepth levels. The clamp keeps the
+// result within one half-spread of the microprice.
+func (l *UiLadder) FairValue(skewFactor float64) (float64, bool) {
+	microprice, ok := l.Microprice()
+	if !ok {
+		return 0, false
+	}
+	imbalance, ok := l.Imbalance(fairValueDepth)
+	if !ok {
+		return 0, false
+	}
+
+	bestBid, _ := bestLevelPrice(l.Bids)
+	bestAsk, _ := bestLevelPrice(l.Asks)
+	skew := math.Max(-1, math.Min(1, skewFactor*imbalance))
+	return microprice + skew*(bestAsk-bestBid)/2, true
+}
+
+// premiumPct compares the realized price of a fill against the top of the
+// book it swept: (avgPrice/bestAsk - 1) * 100 for buys and
+// (1 - avgPrice/bestBid) * 100 for sells. It is zero for an empty book.
+func premiumPct(ladder *UiLadder, side Side, inAmount, outAmount float64) float64 {
+	if side == Bid {
+		bestAsk, ok := bestLevelPrice(ladder.Asks)
+		if !ok || outAmount <= 0 {
+			return 0
+		}
+		avgPrice := inAmount / outAmount
+		return (avgPrice/bestAsk - 1) * 100
+	}
+
+	bestBid, ok := bestLevelPrice(ladder.Bids)
+	if !ok || inAmount <= 0 {
+		return 0
+	}
+	avgPrice := outAmount / inAmount
+	return (1 - avgPrice/bestBid) * 100
+}
+
+// priceImpactBP compares the VWAP of a sweep, fees excluded, with the best price
+// it swept from. spent is the sweep's input after fees and out its output: quote
+// and base for buys, base and quote for sells.
+func priceImpactBP(ladder *UiLadder, side Side, spent, out float64) uint {
+	if spent <= 0 || out <= 0 {
+		return 0
+	}
+	best, ok := bestLevelPrice(takerLevels(ladder, side))
+	if !ok {
+		return 0
+	}
+
+	vwap := spent / out
+	if side == Ask {
+		vwap = out / spent
+	}
+	return uint(math.Abs(vwap-best) / best * FeeScale)
+}
+
+// GenerateLadder builds a symmetric synthetic book around mid: levels bids at
+// mid-tickSize, mid-2*tickSize, ... and levels asks at mid+tickSize, ..., each
+// holding sizePerLevel base units. Bids come out descending and asks ascending.
+// The output depends only on the arguments, which makes it handy for tests and
+// benchmarks.
+func GenerateLadder(mid float64, levels int, tickSize float64, sizePerLevel float64) (*UiLadder, error) {
+	if levels <= 0 {
+		return nil, errors.New("levels must be greater than zero")
+	}
+	if !(tickSize > 0) || !(sizePerLevel > 0) {
+		return nil, fmt.Errorf("invalid tick size %v or level size %v", tickSize, sizePerLevel)
+	}
+	if mid-float64(levels)*tickSize <= 0 {
+		return nil, fmt.Errorf("mid %v is too low for %d levels of tick size %v", mid, levels, tickSize)
+	}
+
+	ladder := &UiLadder{
+		Bids: make([]UiLadderLevel, levels),
+		Asks: make([]UiLadderLevel, levels),
+	}
+	for i := 0; i < levels; i++ {
+		offset := float64(i+1) * tickSize
+		ladder.Bids[i] = UiLadderLevel{Price: mid - offset, Quantity: sizePerLevel}
+		ladder.Asks[i] = UiLadderLevel{Price: mid + offset, Quantity: sizePerLevel}
+	}
+	return ladder, nil
+}
+
+// ParseLadderJSON builds a UiLadder from the [[price, size], ...] arrays used by
+// common exchange snapshots. Bids are sorted descending and asks ascending.
+func ParseLadderJSON(bids, asks []byte) (*UiLadder, error) {
+	bidLevels, err := parseLevelsJSON(bids)
+	if err != nil {
+		return nil, fmt.Errorf("bids: %w", err)
+	}
+	askLevels, err := parseLevelsJSON(asks)
+	if err != nil {
+		return nil, fmt.Errorf("asks: %w", err)
+	}
+
+	sort.SliceStable(bidLevels, func(i, j int) bool { return bidLevels[i].Price > bidLevels[j].Price })
+	sort.SliceStable(askLevels, func(i, j int) bool { return askLevels[i].Price < askLevels[j].Price })
+
+	if err := validateLevels(bidLevels); err != nil {
+		return nil, fmt.Errorf("bids: %w", err)
+	}
+	if err := validateLevels(askLevels); err != nil {
+		return nil, fmt.Errorf("asks: %w", err)
+	}
+
+	return &UiLadder{Bids: bidLevels, Asks: askLevels}, nil
+}
+
+func parseLevelsJSON(data []byte) ([]UiLadderLevel, error) {
+	var pairs [][]float64
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return nil, err
+	}
+
+	levels := make([]UiLadderLevel, 0, len(pairs))
+	for i, pair := range pairs {
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("level %d: expected [price, size], got %d values", i, len(pair))
+		}
+		levels = append(levels, UiLadderLevel{Price: pair[0], Quantity: pair[1]})
+	}
+	return levels, nil
+}
+
+// denormalThreshold scales math.SmallestNonzeroFloat64 up to the smallest
+// normal float64 (2^-1022); anything below it is subnormal.
+const denormalThreshold = 1 << 52
+
+// isDenormal reports whether v is a subnormal float. Such values are
+// effectively zero but do not compare as zero, and distort sweep arithmetic.
+func isDenormal(v float64) bool {
+	return v != 0 && math.Abs(v) < math.SmallestNonzeroFloat64*denormalThreshold
+}
+
+// BuildUiLadder converts the raw orders in h.Data into a ladder, one level per
+// price: ticks become prices with TicksToPrice and lots become base units with
+// BaseLotsToUnits, which apply the header decimals, RawBaseUnitsPerBaseUnit and
+// any explicit lot sizes. Orders that expired before h.Clock are left out, and
+// each level keeps the latest LastValidSlot of its orders. Bids
+// are sorted descending and asks ascending.
+func (h *Hoenix) BuildUiLadder() (*UiLadder, error) {
+	bids := h.buildLevels(h.Data.Bids, true)
+	asks := h.buildLevels(h.Data.Asks, false)
+
+	if err := validateLevels(bids); err != nil {
+		return nil, fmt.Errorf("bids: %w", err)
+	}
+	if err := validateLevels(asks); err != nil {
+		return nil, fmt.Errorf("asks: %w", err)
+	}
+	return &UiLadder{Bids: bids, Asks: asks}, nil
+}
+
+// expired reports whether order's validity ended before h.Clock. A zero slot or
+// timestamp means the order does not expire on that clock.
+func (h *Hoenix) expired(order RawOrder) bool {
+	if order.LastValidSlot != 0 && order.LastValidSlot < h.Clock.Slot {
+		return true
+	}
+	return order.LastValidUnixTimestampInSeconds != 0 && order.LastValidUnixTimestampInSeconds < h.Clock.UnixTimestamp
+}
+
+// buildLevels aggregates orders by price, skipping expired ones. Orders are visited in price and then
+// key order so the sums do not depend on map iteration.
+func (h *Hoenix) buildLevels(orders map[string]RawOrder, descending bool) []UiLadderLevel {
+	keys := make([]string, 0, len(orders))
+	for key, order := range orders {
+		if !h.expired(order) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := orders[keys[i]].PriceInTicks, orders[keys[j]].PriceInTicks
+		if a != b {
+			return (a > b) == descending
+		}
+		return keys[i] < keys[j]
+	})
+
+	var levels []UiLadderLevel
+	for i, key := range keys {
+		order := orders[key]
+		if i > 0 && order.PriceInTicks == orders[keys[i-1]].PriceInTicks {
+			level := &levels[len(levels)-1]
+			level.Quantity += h.BaseLotsToUnits(order.NumBaseLots)
+			level.LastValidSlot = max(level.LastValidSlot, order.LastValidSlot)
+			continue
+		}
+		levels = append(levels, UiLadderLevel{
+			Price:         h.TicksToPrice(order.PriceInTicks),
+			Quantity:      h.BaseLotsToUnits(order.NumBaseLots),
+			LastValidSlot: order.LastValidSlot,
+		})
+	}
+	return levels
+}
+
+// validateLevels rejects levels that cannot be swept: non-finite or subnormal
+// values and non-positive prices or sizes.
+func validateLevels(levels []UiLadderLevel) error {
+	for i, level := range levels {
+		if math.IsNaN(level.Price) || math.IsInf(level.Price, 0) || level.Price <= 0 || isDenormal(level.Price) {
+			return fmt.Errorf("level %d: invalid price %v", i, level.Price)
+		}
+		if math.IsNaN(level.Quantity) || math.IsInf(level.Quantity, 0) || level.Quantity <= 0 || isDenormal(level.Quantity) {
+			return fmt.Errorf("level %d: invalid size %v", i, level.Quantity)
+		}
+	}
+	return nil
+}
+
+// Validate checks that no level has a subnormal price or size, that bids are
+// sorted by descending price, asks by ascending price, and that the best bid is
+// below the best ask. Levels at equal prices are allowed on either side; drained
+// levels are ignored for the crossing check.
+func (l *UiLadder) Validate() error {
+	for i, level := range l.Bids {
+		if isDenormal(level.Price) || isDenormal(level.Quantity) {
+			return fmt.Errorf("bids: level %d: subnormal price %v or size %v", i, level.Price, level.Quantity)
+		}
+	}
+	for i, level := range l.Asks {
+		if isDenormal(level.Price) || isDenormal(level.Quantity) {
+			return fmt.Errorf("asks: level %d: subnormal price %v or size %v", i, level.Price, level.Quantity)
+		}
+	}
+	for i := 1; i < len(l.Bids); i++ {
+		if l.Bids[i].Price > l.Bids[i-1].Price {
+			return fmt.Errorf("bids: level %d: price %v above level %d price %v", i, l.Bids[i].Price, i-1, l.Bids[i-1].Price)
+		}
+	}
+	for i := 1; i < len(l.Asks); i++ {
+		if l.Asks[i].Price < l.Asks[i-1].Price {
+			return fmt.Errorf("asks: level %d: price %v below level %d price %v", i, l.Asks[i].Price, i-1, l.Asks[i-1].Price)
+		}
+	}
+	bid, okBid := l.BestBid()
+	ask, okAsk := l.BestAsk()
+	if okBid && okAsk && bid >= ask {
+		return fmt.Errorf("book is crossed or locked: bid %v, ask %v", bid, ask)
+	}
+	return nil
+}
+
+// checkQuotableLadder rejects levels GetQuote cannot price: a price that is not
+// strictly positive, finite and normal, or a quantity that is negative, not
+// finite or subnormal.
+// Zero quantities are allowed because quoting drains levels in place and later
+// quotes run against the same ladder.
+func checkQuotableLadder(ladder *UiLadder) error {
+	sides := []struct {
+		name   string
+		levels []UiLadderLevel
+	}{{"bids", ladder.Bids}, {"asks", ladder.Asks}}
+	for _, side := range sides {
+		name := side.name
+		for i, level := range side.levels {
+			if !(level.Price > 0) || math.IsInf(level.Price, 0) || isDenormal(level.Price) {
+				return fmt.Errorf("%s: level %d: invalid price %v", name, i, level.Price)
+			}
+			if !(level.Quantity >= 0) || math.IsInf(level.Quantity, 0) || isDenormal(level.Quantity) {
+				return fmt.Errorf("%s: level %d: invalid size %v", name, i, level.Quantity)
+			}
+		}
+	}
+	return nil
+}
+
+// baseLotSize returns BaseLotSize, or when it is unset the size implied by the
+// header: RawBaseUnitsPerBaseUnit lots per whole base token, one lot per token
+// if that is unset too.
+func (h *Hoenix) baseLotSize() float64 {
+	if h.BaseLotSize > 0 {
+		return h.BaseLotSize
+	}
+	lotsPerToken := h.Data.Header.RawBaseUnitsPerBaseUnit
+	if lotsPerToken <= 0 {
+		lotsPerToken = 1
+	}
+	return math.Pow10(h.Data.Header.BaseParams.Decimals) / lotsPerToken
+}
+
+// quoteLotSize returns QuoteLotSize, or one quote atom when it is unset.
+func (h *Hoenix) quoteLotSize() float64 {
+	if h.QuoteLotSize > 0 {
+		return h.QuoteLotSize
+	}
+	return 1
+}
+
+// BaseAmount converts a size in base tokens to an exact venue.Amount in the
+// base token's decimals.
+func (h *Hoenix) BaseAmount(units float64) (venue.Amount, error) {
+	return venue.FromFloat(units, h.Data.Header.BaseParams.Decimals)
+}
+
+// QuoteAmount converts quote tokens to a venue.Amount in the quote token's
+// decimals.
+func (h *Hoenix) QuoteAmount(units float64) (venue.Amount, error) {
+	return venue.FromFloat(units, h.Data.Header.QuoteParams.Decimals)
+}
+
+// LevelAmounts returns what a sweep can take from level as exact amounts: its
+// base size and that size's notional at the level price, truncated to the quote
+// token's decimals.
+func (h *Hoenix) LevelAmounts(level UiLadderLevel) (base, quote venue.Amount, err error) {
+	if base, err = h.BaseAmount(level.available()); err != nil {
+		return venue.Amount{}, venue.Amount{}, err
+	}
+	price, err := h.QuoteAmount(level.Price)
+	if err != nil {
+		return venue.Amount{}, venue.Amount{}, err
+	}
+	notional, err := base.Mul(price)
+	if err != nil {
+		return venue.Amount{}, venue.Amount{}, err
+	}
+	if quote, err = notional.Rescale(h.Data.Header.QuoteParams.Decimals); err != nil {
+		return venue.Amount{}, venue.Amount{}, err
+	}
+	return base, quote, nil
+}
+
+// BaseLotsToUnits converts a size in base lots to base tokens.
+func (h *Hoenix) BaseLotsToUnits(lots float64) float64 {
+	return lots * h.baseLotSize() / math.Pow10(h.Data.Header.BaseParams.Decimals)
+}
+
+// TicksToPrice converts a price in ticks, one quote lot per base token each, to
+// quote tokens per base token.
+func (h *Hoenix) TicksToPrice(ticks float64) float64 {
+	return ticks * h.quoteLotSize() / math.Pow10(h.Data.Header.QuoteParams.Decimals)
+}
+
+// RoundToLots rounds q's output down to whole lots of the token it pays out:
+// base lots for buys and quote lots for sells. Execution can only deliver whole
+// lots, so the rounded amount is what actually arrives.
+func (h *Hoenix) RoundToLots(q *Quote) {
+	lot := h.quoteLotSize() / math.Pow10(h.Data.Header.QuoteParams.Decimals)
+	if q.AToB {
+		lot = h.baseLotSize() / math.Pow10(h.Data.Header.BaseParams.Decimals)
+	}
+	q.OutAmount = math.Floor(q.OutAmount/lot) * lot
+}
+
+// ApplyFraction drains fraction of each of a quote's fills from ladder, for
+// quotes that were priced against a copy of the book and only partly executed.
+// Only the levels the quote filled are touched, so levels it skipped, such as
+// ExcludePrices, keep their size.
+func (h *Hoenix) ApplyFraction(q *Quote, fraction float64, ladder *UiLadder) error {
+	if fraction <= 0 || fraction > 1 {
+		return fmt.Errorf("fraction %v must be in (0, 1]", fraction)
+	}
+	if len(q.Fills) == 0 {
+		return errors.New("quote has no fills to apply")
+	}
+
+	side := Bid
+	if !q.AToB {
+		side = Ask
+	}
+	levels := takerLevels(ladder, side)
+	fills := make([]Fill, len(q.Fills))
+	for i, fill := range q.Fills {
+		if fill.Level < 0 || fill.Level >= len(levels) {
+			return fmt.Errorf("fill %d: level %d is not on the ladder", i, fill.Level)
+		}
+		fill.BaseFilled *= fraction
+		fills[i] = fill
+	}
+	drainFills(levels, fills)
+	ladder.LadderVersion++
+	return nil
+}
+
+// sweepOptions limits how far a sweep may walk the ladder.
+type sweepOptions struct {
+	maxDistinctLevels int             // Distinct prices that may be filled; zero means no limit
+	excludePrices     []float64       // Prices whose levels are skipped
+	allowPartial      bool            // Running out of levels returns what was filled instead of an error
+	ctx               context.Context // Checked at every level; nil means the sweep cannot be canceled
+}
+
+// canceled returns the sweep context's error once it is done.
+func (o sweepOptions) canceled() error {
+	if o.ctx == nil {
+		return nil
+	}
+	return o.ctx.Err()
+}
+
+// priceEpsilon is the relative tolerance within which a level's price matches
+// one of QuoteParams.ExcludePrices.
+const priceEpsilon = 1e-9
+
+// excluded reports whether levels at price must be skipped.
+func (o sweepOptions) excluded(price float64) bool {
+	for _, excluded := range o.excludePrices {
+		if math.Abs(price-excluded) <= priceEpsilon*math.Max(math.Abs(price), math.Abs(excluded)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweepResult is the outcome of walking one side of the ladder.
+type sweepResult struct {
+	amount            float64 // Output of the sweep
+	remaining         float64 // Budget left unspent when the sweep stopped at a limit
+	fullyConsumed     []int   // Indices of levels emptied by the sweep
+	partiallyConsumed *int    // Index of the level the sweep stopped inside, if any
+	fills             []Fill  // What was taken from each level, in sweep order
+	feePaid           float64 // Taker fee in quote units: on top of the input (buys) or deducted from amount (sells)
+}
+
+// consume records that the level at index was filled, in full or in part, by
+// base units at the level's price.
+func (r *sweepResult) consume(index int, partial bool, price, base float64) {
+	r.fills = append(r.fills, Fill{Level: index, Price: price, BaseFilled: base, QuoteFilled: base * price})
+	if partial {
+		r.partiallyConsumed = &index
+		return
+	}
+	r.fullyConsumed = append(r.fullyConsumed, index)
+}
+
+// distinctLevels counts the distinct prices a sweep has filled.
+type distinctLevels struct {
+	limit int
+	count int
+	last  float64
+}
+
+// admit reports whether a level at price may still be filled.
+func (d *distinctLevels) admit(price float64) bool {
+	if d.count > 0 && price == d.last {
+		return true
+	}
+	if d.limit > 0 && d.count == d.limit {
+		return false
+	}
+	d.count++
+	d.last = price
+	return true
+}
+
+func (h *Hoenix) getExpectedOutAmount(uiLadder *UiLadder, side Side, takerFeeBps float64, inAmount float64, opts sweepOptions) (sweepResult, error) {
+	h.logf("Ladder: %+v", uiLadder)
+	if inAmount <= 0 {
+		return sweepResult{}, errors.New("input amount must be greater than zero")
+	}
+
+	// The fee is always charged on the quote leg: the input of a buy and the
+	// proceeds of a sell
+	if side == Bid {
+		tradable := h.applyTakerFee(inAmount, takerFeeBps)
+		result, err := h.getBaseUnitsOutFromQuoteUnitsIn(uiLadder.Asks, tradable, opts)
+		if err != nil {
+			return result, err
+		}
+		// Quote left unspent by a partial sweep carries no fee
+		result.feePaid = inAmount - tradable - result.remaining*takerFeeBps/FeeScale
+		return result, nil
+	}
+	result, err := h.getQuoteUnitsOutFromBaseUnitsIn(uiLadder.Bids, inAmount, opts)
+	if err != nil {
+		return result, err
+	}
+	result.feePaid = result.amount - h.applyOutputFee(result.amount, takerFeeBps)
+	result.amount -= result.feePaid
+	return result, nil
+}
+
+// applyTakerFee returns the part of a quote input left to trade once the fee,
+// charged on top of it, is set aside.
+func (h *Hoenix) applyTakerFee(amount, takerFeeBps float64) float64 {
+	return amount / (1 + takerFeeBps/FeeScale)
+}
+
+// applyOutputFee returns quote proceeds after the taker fee is deducted.
+func (h *Hoenix) applyOutputFee(amount, takerFeeBps float64) float64 {
+	return amount * (1 - takerFeeBps/FeeScale)
+}
+
+// LatencyAdjustedPrice returns q's average price in quote per base, made worse
+// by LatencyPenaltyBps: raised for buys and lowered for sells. Routers can
+// compare it across venues so a faster venue wins at a slightly worse raw price.
+func (h *Hoenix) LatencyAdjustedPrice(q *Quote) float64 {
+	if q.InAmount <= 0 || q.OutAmount <= 0 {
+		return 0
+	}
+	if q.AToB {
+		return q.InAmount / q.OutAmount * (1 + h.LatencyPenaltyBps/FeeScale)
+	}
+	return q.OutAmount / q.InAmount * (1 - h.LatencyPenaltyBps/FeeScale)
+}
+
+// PassiveEffectivePrice returns the effective price of a resting (maker) order
+// filled at price once a rebate of rebateBps is credited: a resting Bid pays
+// less than price and a resting Ask receives more.
+func PassiveEffectivePrice(price float64, rebateBps float64, side Side) float64 {
+	if side == Bid {
+		return price * (1 - rebateBps/FeeScale)
+	}
+	return price * (1 + rebateBps/FeeScale)
+}
+
+// RoundTripFeeDragBps returns the share of size, in basis points, lost to
+// taker fees when entering and then exiting a position. The fee compounds
+// because the exit proceeds are charged on what is left after the entry.
+func (h *Hoenix) RoundTripFeeDragBps(size float64) float64 {
+	if size <= 0 {
+		return 0
+	}
+	afterEntry := h.applyTakerFee(size, h.takerFeeBps(Bid, size))
+	afterExit := h.applyOutputFee(afterEntry, h.takerFeeBps(Ask, afterEntry))
+	return (size - afterExit) / size * FeeScale
+}
+
+func (h *Hoenix) getBaseUnitsOutFromQuoteUnitsIn(asks []UiLadderLevel, quoteUnitsIn float64, opts sweepOptions) (sweepResult, error) {
+	if quoteUnitsIn <= 0 {
+		return sweepResult{}, errors.New("quote units must be greater than zero")
+	}
+	return h.calculateBaseAmountFromQuoteBudget(asks, quoteUnitsIn, opts)
+}
+
+func (h *Hoenix) getQuoteUnitsOutFromBaseUnitsIn(bids []UiLadderLevel, baseUnitsIn float64, opts sweepOptions) (sweepResult, error) {
+	if baseUnitsIn <= 0 {
+		return sweepResult{}, errors.New("base units must be greater than zero")
+	}
+	return h.calculateQuoteAmountFromBaseBudget(bids, baseUnitsIn, opts)
+}
+
+func (h *Hoenix) calculateBaseAmountFromQuoteBudget(asks []UiLadderLevel, quoteBudget float64, opts sweepOptions) (sweepResult, error) {
+	var result sweepResult
+	baseAmount := 0.0
+	levels := distinctLevels{limit: opts.maxDistinctLevels}
+	for i, level := range asks {
+		if err := opts.canceled(); err != nil {
+			return sweepResult{}, err
+		}
+		quantity := level.available()
+		if quantity <= 0 || opts.excluded(level.Price) {
+			continue
+		}
+		if !levels.admit(level.Price) {
+			result.amount, result.remaining = baseAmount, quoteBudget
+			return result, nil
+		}
+		if level.Price*quantity >= quoteBudget {
+			baseAmount += quoteBudget / level.Price
+			result.consume(i, level.Price*quantity > quoteBudget, level.Price, quoteBudget/level.Price)
+			quoteBudget = 0
+			break
+		}
+		baseAmount += quantity
+		quoteBudget -= level.Price * quantity
+		result.consume(i, false, level.Price, quantity)
+		if quoteBudget <= 0 {
+			break
+		}
+	}
+
+	result.amount = baseAmount
+	if quoteBudget > 0 {
+		if opts.allowPartial && baseAmount > 0 {
+			result.remaining = quoteBudget
+			return result, nil
+		}
+		return result, ErrInsufficientLiquidity
+	}
+	h.logf("baseAmount==> %+v", baseAmount)
+	return result, nil
+}
+
+func (h *Hoenix) calculateQuoteAmountFromBaseBudget(bids []UiLadderLevel, baseBudget float64, opts sweepOptions) (sweepResult, error) {
+	var result sweepResult
+	quoteAmount := 0.0
+	levels := distinctLevels{limit: opts.maxDistinctLevels}
+	for i, level := range bids {
+		if err := opts.canceled(); err != nil {
+			return sweepResult{}, err
+		}
+		quantity := level.available()
+		if quantity <= 0 || opts.excluded(level.Price) {
+			continue
+		}
+		if !levels.admit(level.Price) {
+			result.amount, result.remaining = quoteAmount, baseBudget
+			return result, nil
+		}
+		if quantity >= baseBudget {
+			quoteAmount += baseBudget * level.Price
+			result.consume(i, quantity > baseBudget, level.Price, baseBudget)
+			baseBudget = 0
+			break
+		}
+		quoteAmount += quantity * level.Price
+		baseBudget -= quantity
+		result.consume(i, false, level.Price, quantity)
+		if baseBudget <= 0 {
+			break
+		}
+	}
+
+	result.amount = quoteAmount
+	if baseBudget > 0 {
+		if opts.allowPartial && quoteAmount > 0 {
+			result.remaining = baseBudget
+			return result, nil
+		}
+		return result, ErrInsufficientLiquidity
+	}
+	h.logf("quoteAmount==> %+v", quoteAmount)
+	return result, nil
+}
+
+// LadderIndex holds prefix sums over a ladder's asks so that repeated buys
+// against the same static book are priced with a binary search rather than a
+// walk of every level. It is a snapshot: draining the ladder afterwards does not
+// update the index.
+type LadderIndex struct {
+	prices   []float64 // Price of each fillable ask level
+	cumBase  []float64 // Base depth up to and including each level
+	cumQuote []float64 // Quote depth up to and including each level
+}
+
+// NewLadderIndex builds a LadderIndex from the ladder's asks, skipping levels
+// with nothing available as a sweep does.
+func NewLadderIndex(ladder *UiLadder) *LadderIndex {
+	idx := &LadderIndex{}
+	base, quote := 0.0, 0.0
+	for _, level := range ladder.Asks {
+		quantity := level.available()
+		if quantity <= 0 {
+			continue
+		}
+		base += quantity
+		quote += level.Price * quantity
+		idx.prices = append(idx.prices, level.Price)
+		idx.cumBase = append(idx.cumBase, base)
+		idx.cumQuote = append(idx.cumQuote, quote)
+	}
+	return idx
+}
+
+// QuoteBaseOut returns the base units bought by spending quoteBudget on the
+// indexed asks, before fees. Like calculateBaseAmountFromQuoteBudget it returns
+// the whole book's depth with ErrInsufficientLiquidity when the budget exceeds it.
+func (idx *LadderIndex) QuoteBaseOut(quoteBudget float64) (float64, error) {
+	if quoteBudget <= 0 {
+		return 0, errors.New("quote units must be greater than zero")
+	}
+	n := len(idx.cumQuote)
+	if n == 0 {
+		return 0, ErrInsufficientLiquidity
+	}
+	if quoteBudget > idx.cumQuote[n-1] {
+		return idx.cumBase[n-1], ErrInsufficientLiquidity
+	}
+
+	// First level whose cumulative notional covers the budget
+	i := sort.SearchFloat64s(idx.cumQuote, quoteBudget)
+	baseBefore, quoteBefore := 0.0, 0.0
+	if i > 0 {
+		baseBefore, quoteBefore = idx.cumBase[i-1], idx.cumQuote[i-1]
+	}
+	return baseBefore + (quoteBudget-quoteBefore)/idx.prices[i], nil
+}
+
+// takerLevels returns the levels a taker on the given side sweeps: asks for a
+// Bid (buy) and bids for an Ask (sell).
+func takerLevels(ladder *UiLadder, side Side) []UiLadderLevel {
+	if side == Bid {
+		return ladder.Asks
+	}
+	return ladder.Bids
+}
+
+// sweepCursor walks a side of the ladder in order, filling base quantity level
+// by level. It can be advanced repeatedly to measure cumulative fills in one pass.
+type sweepCursor struct {
+	levels []UiLadderLevel
+	index  int     // Level currently being filled
+	used   float64 // Base already taken from levels[index]
+}
+
+// fillBase takes up to base units from the remaining levels and returns what was
+// filled together with the price of the last level touched.
+func (c *sweepCursor) fillBase(base float64) (filledBase, filledQuote, lastPrice float64) {
+	for base > 0 && c.index < len(c.levels) {
+		level := c.levels[c.index]
+		remaining := level.available() - c.used
+		if remaining <= 0 {
+			c.index++
+			c.used = 0
+			continue
+		}
+
+		take := math.Min(remaining, base)
+		filledBase += take
+		filledQuote += take * level.Price
+		lastPrice = level.Price
+		base -= take
+		c.used += take
+		if c.used >= level.available() {
+			c.index++
+			c.used = 0
+		}
+	}
+	return filledBase, filledQuote, lastPrice
+}
+
+// fillQuote is fillBase for a notional: it takes levels until quote units of
+// price times size have been filled.
+func (c *sweepCursor) fillQuote(quote float64) (filledBase, filledQuote float64) {
+	for quote > 0 && c.index < len(c.levels) {
+		level := c.levels[c.index]
+		remaining := level.available() - c.used
+		if remaining <= 0 {
+			c.index++
+			c.used = 0
+			continue
+		}
+
+		take := math.Min(remaining, quote/level.Price)
+		filledBase += take
+		filledQuote += take * level.Price
+		quote -= take * level.Price
+		c.used += take
+		if c.used >= level.available() {
+			c.index++
+			c.used = 0
+		}
+	}
+	return filledBase, filledQuote
+}
+
+// PriceAtFillFraction returns the marginal price at which each fraction of
+// totalSize (in base units) is filled when a taker on side sweeps the ladder.
+// Fractions must be in (0, 1] and non-decreasing.
+func PriceAtFillFraction(ladder *UiLadder, side Side, totalSize float64, fractions []float64) ([]float64, error) {
+	if totalSize <= 0 {
+		return nil, errors.New("total size must be greater than zero")
+	}
+
+	cursor := sweepCursor{levels: takerLevels(ladder, side)}
+	prices := make([]float64, len(fractions))
+	filled, lastPrice := 0.0, 0.0
+	for i, fraction := range fractions {
+		if fraction <= 0 || fraction > 1 {
+			return nil, fmt.Errorf("fraction %v must be in (0, 1]", fraction)
+		}
+		if i > 0 && fraction < fractions[i-1] {
+			return nil, errors.New("fractions must be non-decreasing")
+		}
+
+		target := fraction * totalSize
+		if target > filled {
+			base, _, price := cursor.fillBase(target - filled)
+			filled += base
+			if base > 0 {
+				lastPrice = price
+			}
+			if filled < target {
+				return nil, ErrInsufficientLiquidity
+			}
+		}
+		prices[i] = lastPrice
+	}
+	return prices, nil
+}
+
+// EffectivePrice returns the average price, before fees, of sweeping size base
+// units as a taker on side.
+func EffectivePrice(ladder *UiLadder, side Side, size float64) (float64, error) {
+	if size <= 0 {
+		return 0, errors.New("size must be greater than zero")
+	}
+
+	cursor := sweepCursor{levels: takerLevels(ladder, side)}
+	base, quote, _ := cursor.fillBase(size)
+	if base < size {
+		return 0, ErrInsufficientLiquidity
+	}
+	return quote / base, nil
+}
+
+// WorstCasePrice returns the least favourable EffectivePrice for any size in
+// [minSize, maxSize]. Sweep prices only worsen with size, so this is the price
+// at maxSize.
+func WorstCasePrice(ladder *UiLadder, side Side, minSize, maxSize float64) (float64, error) {
+	if minSize <= 0 || maxSize < minSize {
+		return 0, errors.New("size range must be positive and non-empty")
+	}
+	return EffectivePrice(ladder, side, maxSize)
+}
+
+// PriceForSizeCurve returns the EffectivePrice for each of sizes, which must be
+// non-decreasing, in a single pass over the ladder.
+func PriceForSizeCurve(ladder *UiLadder, side Side, sizes []float64) (prices []float64, err error) {
+	cursor := sweepCursor{levels: takerLevels(ladder, side)}
+	prices = make([]float64, len(sizes))
+	filledBase, filledQuote := 0.0, 0.0
+	for i, size := range sizes {
+		if size <= 0 {
+			return nil, errors.New("size must be greater than zero")
+		}
+		if i > 0 && size < sizes[i-1] {
+			return nil, errors.New("sizes must be non-decreasing")
+		}
+
+		if size > filledBase {
+			base, quote, _ := cursor.fillBase(size - filledBase)
+			filledBase += base
+			filledQuote += quote
+			if filledBase < size {
+				return nil, ErrInsufficientLiquidity
+			}
+		}
+		prices[i] = filledQuote / filledBase
+	}
+	return prices, nil
+}
+
+// MidpointImprovementBps estimates the saving, in basis points of the sweep
+// price, of filling size base units at the book midpoint instead of sweeping
+// as a taker on side. Fees are not included.
+func MidpointImprovementBps(ladder *UiLadder, side Side, size float64) (float64, error) {
+	bestBid, ok := bestLevelPrice(ladder.Bids)
+	if !ok {
+		return 0, errors.New("ladder has no bids")
+	}
+	bestAsk, ok := bestLevelPrice(ladder.Asks)
+	if !ok {
+		return 0, errors.New("ladder has no asks")
+	}
+	mid := (bestBid + bestAsk) / 2
+
+	sweepPrice, err := EffectivePrice(ladder, side, size)
+	if err != nil {
+		return 0, err
+	}
+	if side == Bid {
+		return (sweepPrice - mid) / sweepPrice * FeeScale, nil
+	}
+	return (mid - sweepPrice) / sweepPrice * FeeScale, nil
+}
+
+// MakerQuotes returns the bid and ask to post for a spread of targetSpreadBps of
+// the mid, centered on the mid but never outside the current best bid and ask:
+// when the target is wider than the market, the quotes join the top of book.
+func MakerQuotes(ladder *UiLadder, targetSpreadBps float64) (bid, ask float64, err error) {
+	if targetSpreadBps <= 0 {
+		return 0, 0, errors.New("target spread must be greater than zero")
+	}
+	bestBid, ok := bestLevelPrice(ladder.Bids)
+	if !ok {
+		return 0, 0, errors.New("ladder has no bids")
+	}
+	bestAsk, ok := bestLevelPrice(ladder.Asks)
+	if !ok {
+		return 0, 0, errors.New("ladder has no asks")
+	}
+	if bestBid >= bestAsk {
+		return 0, 0, fmt.Errorf("book is crossed or locked: bid %v, ask %v", bestBid, bestAsk)
+	}
+
+	mid := (bestBid + bestAsk) / 2
+	halfSpread := mid * targetSpreadBps / FeeScale / 2
+	return math.Max(mid-halfSpread, bestBid), math.Min(mid+halfSpread, bestAsk), nil
+}
+
+// MaxSizeForAvgPrice returns the largest fill a taker on side can make while
+// keeping the running average price at or under maxAvg for buys (at or above
+// it for sells). Whole levels are taken while the average allows, then the
+// boundary level is filled up to the point where the average hits maxAvg.
+// Fees are not included.
+func MaxSizeForAvgPrice(ladder *UiLadder, side Side, maxAvg float64) (*Quote, error) {
+	if maxAvg <= 0 {
+		return nil, errors.New("average price limit must be greater than zero")
+	}
+
+	base, quote := 0.0, 0.0
+	for _, level := range takerLevels(ladder, side) {
+		quantity := level.available()
+		if quantity <= 0 {
+			continue
+		}
+
+		nextBase := base + quantity
+		nextQuote := quote + quantity*level.Price
+		if (side == Bid && nextQuote <= maxAvg*nextBase) || (side == Ask && nextQuote >= maxAvg*nextBase) {
+			base, quote = nextBase, nextQuote
+			continue
+		}
+
+		// Solve (quote + price*x) / (base + x) = maxAvg for the partial fill
+		partial := (maxAvg*base - quote) / (level.Price - maxAvg)
+		if partial > 0 {
+			base += partial
+			quote += partial * level.Price
+		}
+		break
+	}
+
+	if base == 0 {
+		return nil, errors.New("no liquidity within the average price limit")
+	}
+	if side == Bid {
+		return &Quote{InAmount: quote, OutAmount: base, AToB: true}, nil
+	}
+	return &Quote{InAmount: base, OutAmount: quote, AToB: false}, nil
+}
+
+// drainFills removes each fill's base from the level it was taken from.
+func drainFills(levels []UiLadderLevel, fills []Fill) {
+	for _, fill := range fills {
+		levels[fill.Level].drain(fill.BaseFilled)
+	}
+}
@@ -0,0 +1,1669 @@
+package phoenix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sampleLadder is the book from cmd/example.
+func sampleLadder() *UiLadder {
+	return &UiLadder{
+		Bids: []UiLadderLevel{{Price: 20, Quantity: 10}, {Price: 15, Quantity: 5}, {Price: 10, Quantity: 2}},
+		Asks: []UiLadderLevel{{Price: 25, Quantity: 10}, {Price: 30, Quantity: 5}, {Price: 35, Quantity: 2}},
+	}
+}
+
+// sampleHoenix returns a market charging a flat 5 bps taker fee.
+func sampleHoenix() *Hoenix {
+	h := &Hoenix{}
+	h.Data.TakerFeeBps = 5
+	return h
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+func TestQuoteAssumingTopStale(t *testing.T) {
+	h, ladder := sampleHoenix(), sampleLadder()
+	q, err := h.QuoteAssumingTopStale(ladder, QuoteParams{InAmount: 200, AToB: true}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Fills) != 2 || q.Fills[0].Level != 1 || q.Fills[0].Price != 30 || q.Fills[1].Level != 2 {
+		t.Fatalf("fills = %+v, want levels 1 and 2 of the full book", q.Fills)
+	}
+	if len(q.FullyConsumed) != 1 || q.FullyConsumed[0] != 1 || q.PartiallyConsumed == nil || *q.PartiallyConsumed != 2 {
+		t.Errorf("consumed = %v, %v; want [1], 2", q.FullyConsumed, q.PartiallyConsumed)
+	}
+	if !reflect.DeepEqual(ladder, sampleLadder()) {
+		t.Error("QuoteAssumingTopStale modified the ladder")
+	}
+
+	// The shifted indices apply to the caller's book
+	if err := h.ApplyFraction(q, 1, ladder); err != nil {
+		t.Fatal(err)
+	}
+	if ladder.Asks[0].Quantity != 10 || !approx(ladder.Asks[1].Quantity, 0) {
+		t.Errorf("asks = %+v, want the 25 level untouched and the 30 level empty", ladder.Asks)
+	}
+}
+
+func TestApplyFractionDrainsFills(t *testing.T) {
+	h, ladder := sampleHoenix(), sampleLadder()
+	q, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 100, AToB: true, ExcludePrices: []float64{25}}, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ApplyFraction(q, 0.5, ladder); err != nil {
+		t.Fatal(err)
+	}
+
+	if ladder.Asks[0].Quantity != 10 {
+		t.Errorf("excluded level holds %v, want it untouched", ladder.Asks[0].Quantity)
+	}
+	if want := 5 - q.Fills[0].BaseFilled/2; !approx(ladder.Asks[1].Quantity, want) {
+		t.Errorf("level 1 holds %v, want %v", ladder.Asks[1].Quantity, want)
+	}
+	if ladder.LadderVersion != 1 {
+		t.Errorf("version = %d, want 1", ladder.LadderVersion)
+	}
+
+	if err := h.ApplyFraction(q, 1.5, ladder); err == nil {
+		t.Error("expected an error for a fraction above 1")
+	}
+}
+
+func TestFillOrKillWithLevelLimit(t *testing.T) {
+	ladder := sampleLadder()
+	// One level holds only 250 of the 300
+	params := QuoteParams{InAmount: 300, AToB: true, FillOrKill: true, MaxDistinctLevels: 1}
+	if q, _, err := sampleHoenix().GetQuote(params, ladder); !errors.Is(err, ErrFillOrKillUnmet) {
+		t.Fatalf("quote = %+v, err = %v; want ErrFillOrKillUnmet", q, err)
+	}
+	if !reflect.DeepEqual(ladder, sampleLadder()) {
+		t.Error("a killed order drained the ladder")
+	}
+
+	params.InAmount = 200
+	if _, _, err := sampleHoenix().GetQuote(params, ladder); err != nil {
+		t.Errorf("order within the first level: %v", err)
+	}
+}
+
+func TestSubnormalLevelIsRejected(t *testing.T) {
+	ladder := sampleLadder()
+	ladder.Asks = append([]UiLadderLevel{{Price: 5e-324, Quantity: 1}}, ladder.Asks...)
+	q, _, err := sampleHoenix().GetQuote(QuoteParams{InAmount: 1, AToB: true}, ladder)
+	if err == nil || !strings.Contains(err.Error(), "asks: level 0: invalid price") {
+		t.Fatalf("quote = %+v, err = %v; want an invalid price error for ask 0", q, err)
+	}
+	if err := ladder.Validate(); err == nil {
+		t.Error("Validate accepted a subnormal ask price")
+	}
+
+	ladder = sampleLadder()
+	ladder.Bids[1].Quantity = 5e-324
+	if _, _, err := sampleHoenix().GetQuote(QuoteParams{InAmount: 1}, ladder); err == nil {
+		t.Error("GetQuote accepted a subnormal bid size")
+	}
+	if err := ladder.Validate(); err == nil {
+		t.Error("Validate accepted a subnormal bid size")
+	}
+}
+
+func TestQuoteValidate(t *testing.T) {
+	h := sampleHoenix()
+	for _, params := range []QuoteParams{{InAmount: 400, AToB: true}, {InAmount: 12}, {InAmount: 100, AToB: true, FlatFee: 1}} {
+		q, err := h.GetQuoteReadOnly(params, sampleLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Validate(); err != nil {
+			t.Errorf("quote for %+v: %v", params, err)
+		}
+	}
+
+	// A 2 bps rebate fills below the best price
+	rebate := sampleHoenix()
+	rebate.FeeTier = FeeTierFunc(func(float64) float64 { return -2 })
+	for _, params := range []QuoteParams{{InAmount: 100, AToB: true}, {InAmount: 5}} {
+		q, err := rebate.GetQuoteReadOnly(params, sampleLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.PremiumPct >= 0 || q.FeePaid >= 0 {
+			t.Errorf("rebated quote = %+v, want a negative premium and fee", q)
+		}
+		if err := q.Validate(); err != nil {
+			t.Errorf("rebated quote for %+v: %v", params, err)
+		}
+	}
+
+	valid, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 400, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string]func(q *Quote){
+		"rebate beyond the bound":     func(q *Quote) { q.FeeBps = -MaxRebateBps - 1 },
+		"premium below the rebate":    func(q *Quote) { q.PremiumPct = -0.5 },
+		"infinite premium":            func(q *Quote) { q.PremiumPct = math.Inf(1) },
+		"fee above the input":         func(q *Quote) { q.FeePaid = q.InAmount + 1 },
+		"price outside the fills":     func(q *Quote) { q.Fills[0].BaseFilled /= 2 },
+		"fill price outside the book": func(q *Quote) { q.InAmount *= 2 },
+	} {
+		q := *valid
+		q.Fills = append([]Fill(nil), valid.Fills...)
+		corrupt(&q)
+		if err := q.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted %+v", name, q)
+		}
+	}
+
+	sell, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 12}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sell.PremiumPct = 100.5
+	if err := sell.Validate(); err == nil {
+		t.Error("Validate accepted a sell more than 100% below the best bid")
+	}
+
+	// A buy sweeping a steep book pays far more than 100% over the best ask:
+	// 1 base at 1 and 49.9 at 10 for 500 in averages about 9.82
+	steep := &UiLadder{
+		Bids: []UiLadderLevel{{Price: 0.5, Quantity: 1}},
+		Asks: []UiLadderLevel{{Price: 1, Quantity: 1}, {Price: 10, Quantity: 100}},
+	}
+	q, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 500, AToB: true}, steep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.PremiumPct <= 100 {
+		t.Fatalf("steep buy premium = %v%%, want above 100%%", q.PremiumPct)
+	}
+	if err := q.Validate(); err != nil {
+		t.Errorf("steep buy: %v", err)
+	}
+}
+
+func TestQuoteHash(t *testing.T) {
+	q := &Quote{InAmount: 300, OutAmount: 11.9940029985, PremiumPct: 4.2, AToB: true}
+	noisy := *q
+	noisy.OutAmount += 1e-12
+	if q.Hash() != noisy.Hash() {
+		t.Error("quotes equal at HashPrecision hash differently")
+	}
+
+	for _, other := range []Quote{
+		{InAmount: 300, OutAmount: 11.9940029985, PremiumPct: 4.2},
+		{InAmount: 300, OutAmount: 11.994002998, PremiumPct: 4.2, AToB: true},
+	} {
+		if other.Hash() == q.Hash() {
+			t.Errorf("%+v hashes like %+v", other, q)
+		}
+	}
+
+	// Amounts beyond int64 nanounits stay distinct
+	large, larger := &Quote{InAmount: 1e11, OutAmount: 1}, &Quote{InAmount: 2e11, OutAmount: 1}
+	if large.Hash() == larger.Hash() {
+		t.Error("1e11 and 2e11 hash identically")
+	}
+
+	zero, negativeZero := &Quote{InAmount: 1, OutAmount: 1}, &Quote{InAmount: 1, OutAmount: 1, PremiumPct: -1e-12}
+	if zero.Hash() != negativeZero.Hash() {
+		t.Error("a premium rounding to -0 hashes differently from 0")
+	}
+
+	// Every field reaches the digest
+	level := 1
+	for name, change := range map[string]func(*Quote){
+		"FeeBps":            func(q *Quote) { q.FeeBps = 5 },
+		"FeePaid":           func(q *Quote) { q.FeePaid = 0.15 },
+		"PriceImpactBP":     func(q *Quote) { q.PriceImpactBP = 12 },
+		"FlatFee":           func(q *Quote) { q.FlatFee = 1 },
+		"Partial":           func(q *Quote) { q.Partial = true },
+		"Filled":            func(q *Quote) { q.Filled = 300 },
+		"Requested":         func(q *Quote) { q.Requested = 400 },
+		"FullyConsumed":     func(q *Quote) { q.FullyConsumed = []int{0} },
+		"PartiallyConsumed": func(q *Quote) { q.PartiallyConsumed = &level },
+		"Fills":             func(q *Quote) { q.Fills = []Fill{{Level: 0, Price: 25, BaseFilled: 10, QuoteFilled: 250}} },
+	} {
+		changed := *q
+		change(&changed)
+		if changed.Hash() == q.Hash() {
+			t.Errorf("changing %s leaves the hash unchanged", name)
+		}
+	}
+	withFill := *q
+	withFill.Fills = []Fill{{Level: 0, Price: 25, BaseFilled: 10, QuoteFilled: 250}}
+	otherFill := withFill
+	otherFill.Fills = []Fill{{Level: 0, Price: 25, BaseFilled: 10, QuoteFilled: 251}}
+	if withFill.Hash() == otherFill.Hash() {
+		t.Error("fills differing in QuoteFilled hash identically")
+	}
+}
+
+func TestFeeTierSizedByNotional(t *testing.T) {
+	h := sampleHoenix()
+	var sizes []float64
+	h.FeeTier = FeeTierFunc(func(size float64) float64 {
+		sizes = append(sizes, size)
+		if size < 100 {
+			return 10
+		}
+		return 2
+	})
+
+	// 12 base at the 20 bid is 240 quote, the same tier as a 240 quote buy
+	for _, params := range []QuoteParams{{InAmount: 240, AToB: true}, {InAmount: 12}} {
+		sizes = nil
+		q, err := h.GetQuoteReadOnly(params, sampleLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.FeeBps != 2 || len(sizes) != 1 || sizes[0] != 240 {
+			t.Errorf("%+v: fee = %v bps for sizes %v; want 2 bps for 240", params, q.FeeBps, sizes)
+		}
+	}
+
+	q, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 4}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.FeeBps != 10 {
+		t.Errorf("4 base sold: fee = %v bps, want 10", q.FeeBps)
+	}
+}
+
+func TestGetQuoteExactOutGrossTier(t *testing.T) {
+	h := sampleHoenix()
+	// 10 base costs 250 net; 10 bps on that grosses up past the tier boundary
+	h.FeeTier = FeeTierFunc(func(size float64) float64 {
+		if size < 250.1 {
+			return 10
+		}
+		return 20
+	})
+
+	buy, err := h.GetQuoteExactOut(10, true, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buy.FeeBps != 20 || !approx(buy.InAmount, 250*1.002) {
+		t.Errorf("buy = %+v, want 250.5 in at 20 bps", buy)
+	}
+	back, err := h.GetQuoteReadOnly(QuoteParams{InAmount: buy.InAmount, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.FeeBps != buy.FeeBps || !approx(back.OutAmount, 10) {
+		t.Errorf("GetQuote of the exact-out input = %+v, want 10 out at %v bps", back, buy.FeeBps)
+	}
+}
+
+func TestQuoteCache(t *testing.T) {
+	h, ladder := sampleHoenix(), sampleLadder()
+	cache := NewQuoteCache(h, ladder)
+
+	first, err := cache.Quote(300, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := cache.Quote(300, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Error("repeat request was not served from the cache")
+	}
+
+	// Executing against the ladder bumps LadderVersion and invalidates the cache
+	if _, _, err := h.GetQuote(QuoteParams{InAmount: 100, AToB: true}, ladder); err != nil {
+		t.Fatal(err)
+	}
+	if ladder.LadderVersion != 1 {
+		t.Fatalf("LadderVersion = %d, want 1", ladder.LadderVersion)
+	}
+	fresh, err := cache.Quote(300, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == first || fresh.OutAmount >= first.OutAmount {
+		t.Errorf("quote after the drain = %+v, want a fresh, smaller fill than %+v", fresh, first)
+	}
+}
+
+func TestPremiumPct(t *testing.T) {
+	h := &Hoenix{}
+	for _, c := range []struct {
+		params QuoteParams
+		want   float64
+	}{
+		// 150 quote at the 25 ask: no premium
+		{QuoteParams{InAmount: 150, AToB: true}, 0},
+		// 300 quote buys 10 at 25 and 5/3 at 30, averaging 25.714
+		{QuoteParams{InAmount: 300, AToB: true}, (300/(10+50.0/30)/25 - 1) * 100},
+		// 12 base sells 10 at 20 and 2 at 15 for 230, averaging 19.167
+		{QuoteParams{InAmount: 12}, (1 - 230.0/12/20) * 100},
+	} {
+		q, err := h.GetQuoteReadOnly(c.params, sampleLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !approx(q.PremiumPct, c.want) {
+			t.Errorf("%+v: premium = %v%%, want %v%%", c.params, q.PremiumPct, c.want)
+		}
+	}
+}
+
+func TestParseLadderJSON(t *testing.T) {
+	ladder, err := ParseLadderJSON([]byte(`[[15, 5], [20, 10], [10, 2]]`), []byte(`[[30, 5], [25, 10], [35, 2]]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ladder, sampleLadder()) {
+		t.Errorf("ladder = %+v, want the sample book sorted best first", ladder)
+	}
+
+	for name, c := range map[string]struct{ bids, asks, want string }{
+		"not JSON":        {`[[20, 10]`, `[]`, "bids:"},
+		"wrong arity":     {`[[20, 10]]`, `[[25, 10, 1]]`, "asks: level 0: expected [price, size], got 3 values"},
+		"string values":   {`[["20", "10"]]`, `[]`, "bids:"},
+		"negative price":  {`[[-20, 10]]`, `[]`, "bids: level 0: invalid price"},
+		"zero size":       {`[]`, `[[25, 0]]`, "asks: level 0: invalid size"},
+		"subnormal price": {`[[5e-324, 10]]`, `[]`, "bids: level 0: invalid price"},
+	} {
+		if _, err := ParseLadderJSON([]byte(c.bids), []byte(c.asks)); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: err = %v, want it to mention %q", name, err, c.want)
+		}
+	}
+}
+
+func TestQueueAheadReducesFill(t *testing.T) {
+	h, ladder := &Hoenix{}, sampleLadder()
+	// Only 6 of the 10 at 25 are ours to take; the rest of 200 goes at 30
+	ladder.Asks[0].QueueAhead = 4
+	q, _, err := h.GetQuote(QuoteParams{InAmount: 200, AToB: true}, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 6 + 50.0/30; !approx(q.OutAmount, want) {
+		t.Errorf("out = %v, want %v", q.OutAmount, want)
+	}
+	if len(q.Fills) != 2 || q.Fills[0].BaseFilled != 6 {
+		t.Errorf("fills = %+v, want 6 at 25 then the rest at 30", q.Fills)
+	}
+	if ladder.Asks[0].Quantity != 4 || ladder.Asks[0].QueueAhead != 4 {
+		t.Errorf("boundary level = %+v, want the queue ahead left resting", ladder.Asks[0])
+	}
+
+	// Without the queue the whole order fills at 25
+	q, err = h.GetQuoteReadOnly(QuoteParams{InAmount: 200, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.OutAmount != 8 {
+		t.Errorf("out without a queue = %v, want 8", q.OutAmount)
+	}
+}
+
+func TestPriceAtFillFractionQuartiles(t *testing.T) {
+	// Four levels of 4 base each, one per quartile of a 16 base order
+	ladder := &UiLadder{
+		Bids: []UiLadderLevel{{Price: 9, Quantity: 4}, {Price: 8, Quantity: 4}, {Price: 7, Quantity: 4}, {Price: 6, Quantity: 4}},
+		Asks: []UiLadderLevel{{Price: 10, Quantity: 4}, {Price: 11, Quantity: 4}, {Price: 12, Quantity: 4}, {Price: 13, Quantity: 4}},
+	}
+	fractions := []float64{0.25, 0.3, 0.5, 0.75, 1}
+	for side, want := range map[Side][]float64{Bid: {10, 11, 11, 12, 13}, Ask: {9, 8, 8, 7, 6}} {
+		prices, err := PriceAtFillFraction(ladder, side, 16, fractions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(prices, want) {
+			t.Errorf("side %v: prices = %v, want %v", side, prices, want)
+		}
+	}
+
+	if _, err := PriceAtFillFraction(ladder, Bid, 20, []float64{0.5, 1}); !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("err = %v, want ErrInsufficientLiquidity", err)
+	}
+	if _, err := PriceAtFillFraction(ladder, Bid, 16, []float64{0.5, 0.25}); err == nil {
+		t.Error("expected an error for decreasing fractions")
+	}
+}
+
+func TestAbsoluteMinOut(t *testing.T) {
+	h, ladder := sampleHoenix(), sampleLadder()
+	// 150 quote buys 150/1.0005/25 = 5.997 base
+	_, _, err := h.GetQuote(QuoteParams{InAmount: 150, AToB: true, AbsoluteMinOut: 6}, ladder)
+	if !errors.Is(err, ErrBelowFloor) || errors.Is(err, ErrSlippageExceeded) {
+		t.Errorf("err = %v, want ErrBelowFloor only", err)
+	}
+	if !reflect.DeepEqual(ladder, sampleLadder()) {
+		t.Error("a quote below the floor drained the ladder")
+	}
+	if _, _, err := h.GetQuote(QuoteParams{InAmount: 150, AToB: true, AbsoluteMinOut: 5.99}, ladder); err != nil {
+		t.Errorf("quote above the floor: %v", err)
+	}
+}
+
+func TestRoundTripFeeDragBps(t *testing.T) {
+	h := sampleHoenix()
+	// 5 bps in on top of the spend, then 5 bps out of the proceeds:
+	// 1 - 0.9995/1.0005 of the size
+	want := (1 - 0.9995/1.0005) * 10_000
+	for _, size := range []float64{1, 1000} {
+		if got := h.RoundTripFeeDragBps(size); !approx(got, want) {
+			t.Errorf("size %v: drag = %v bps, want %v", size, got, want)
+		}
+	}
+	if got := h.RoundTripFeeDragBps(0); got != 0 {
+		t.Errorf("zero size: drag = %v, want 0", got)
+	}
+
+	h.Data.BuyFeeBps, h.Data.SellFeeBps = 10, 0
+	if got, want := h.RoundTripFeeDragBps(1000), (1-0.9995/1.001)*10_000; !approx(got, want) {
+		t.Errorf("10 bps buy, 5 bps sell: drag = %v bps, want %v", got, want)
+	}
+}
+
+func TestBuyAndSellFees(t *testing.T) {
+	h := sampleHoenix()
+	h.Data.BuyFeeBps, h.Data.SellFeeBps = 10, 20
+
+	// 250 quote less 10 bps on top buys 250/1.001 worth of the 25 ask
+	buy, _, err := h.GetQuote(QuoteParams{InAmount: 250, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 250 / 1.001 / 25; !approx(buy.OutAmount, want) || buy.FeeBps != 10 {
+		t.Errorf("buy = %v out at %v bps, want %v at 10", buy.OutAmount, buy.FeeBps, want)
+	}
+
+	// 5 base at the 20 bid less 20 bps
+	sell, _, err := h.GetQuote(QuoteParams{InAmount: 5, AToB: false}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 100 * 0.998; !approx(sell.OutAmount, want) || sell.FeeBps != 20 {
+		t.Errorf("sell = %v out at %v bps, want %v at 20", sell.OutAmount, sell.FeeBps, want)
+	}
+
+	// Unset side fees fall back to TakerFeeBps
+	h.Data.BuyFeeBps, h.Data.SellFeeBps = 0, 0
+	sell, _, err = h.GetQuote(QuoteParams{InAmount: 5, AToB: false}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 100 * 0.9995; !approx(sell.OutAmount, want) {
+		t.Errorf("sell with no side fee = %v, want %v", sell.OutAmount, want)
+	}
+}
+
+func TestMaxSizeForAvgPrice(t *testing.T) {
+	// The first two ask levels average 26.67; the 35 level would lift the
+	// average past 27, so it is only filled up to that point
+	q, err := MaxSizeForAvgPrice(sampleLadder(), Bid, 27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(q.OutAmount, 15.625) || !approx(q.InAmount, 421.875) {
+		t.Errorf("quote = %+v, want 421.875 quote for 15.625 base", q)
+	}
+	if avg := q.InAmount / q.OutAmount; !approx(avg, 27) {
+		t.Errorf("average price = %v, want 27", avg)
+	}
+
+	// Selling with a floor of 18 takes the 20 and 15 bids, averaging 18.33,
+	// and the 10 level only until the average falls to 18
+	q, err = MaxSizeForAvgPrice(sampleLadder(), Ask, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(q.InAmount, 15.625) || !approx(q.OutAmount, 281.25) {
+		t.Errorf("quote = %+v, want 15.625 base for 281.25 quote", q)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	h := sampleHoenix()
+	h.MarketStates = map[string]MarketState{"SOL/USDC": {}}
+	h.Clock = ClockData{Slot: 100, UnixTimestamp: 1_700_000_000}
+	h.MaxBookAgeSlots = 50
+	h.Data.Bids = map[string]RawOrder{"a": {LastValidSlot: 120, NumBaseLots: 3, PriceInTicks: 20}}
+	h.Data.Asks = map[string]RawOrder{"b": {LastValidSlot: 130, NumBaseLots: 4, PriceInTicks: 25}}
+	h.Data.Header.BaseParams.Decimals = 9
+	h.Data.Header.QuoteParams.Decimals = 6
+	h.Data.Header.RawBaseUnitsPerBaseUnit = 1000
+	h.Data.SellFeeBps = 7
+
+	data, err := h.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, h) {
+		t.Fatalf("reloaded market = %+v, want %+v", loaded, h)
+	}
+
+	for _, params := range []QuoteParams{{InAmount: 300, AToB: true}, {InAmount: 12}} {
+		want, err := h.GetQuoteReadOnly(params, sampleLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := loaded.GetQuoteReadOnly(params, sampleLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("reloaded quote = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestDenomQuoteMatchesBaseLadder(t *testing.T) {
+	// sampleLadder with every quantity restated as quote notional
+	notionalLadder := func() *UiLadder {
+		ladder := sampleLadder()
+		for _, side := range [][]UiLadderLevel{ladder.Bids, ladder.Asks} {
+			for i := range side {
+				side[i].Quantity *= side[i].Price
+				side[i].Denom = DenomQuote
+			}
+		}
+		return ladder
+	}
+
+	h := sampleHoenix()
+	for _, params := range []QuoteParams{{InAmount: 300, AToB: true}, {InAmount: 12, AToB: false}} {
+		want, base, err := h.GetQuote(params, sampleLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, quote, err := h.GetQuote(params, notionalLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !approx(got.OutAmount, want.OutAmount) || !approx(got.FeePaid, want.FeePaid) {
+			t.Errorf("aToB %v: quote-denominated quote = %+v, want %+v", params.AToB, got, want)
+		}
+		// Drained levels keep their denomination
+		for i := range base.Asks {
+			if !approx(quote.Asks[i].Quantity, base.Asks[i].Quantity*base.Asks[i].Price) {
+				t.Errorf("ask %d left %v quote, want %v", i, quote.Asks[i].Quantity, base.Asks[i].Quantity*base.Asks[i].Price)
+			}
+		}
+		for i := range base.Bids {
+			if !approx(quote.Bids[i].Quantity, base.Bids[i].Quantity*base.Bids[i].Price) {
+				t.Errorf("bid %d left %v quote, want %v", i, quote.Bids[i].Quantity, base.Bids[i].Quantity*base.Bids[i].Price)
+			}
+		}
+	}
+}
+
+func TestPriceForSizeCurve(t *testing.T) {
+	sizes := []float64{5, 10, 12, 17}
+	for _, side := range []Side{Bid, Ask} {
+		prices, err := PriceForSizeCurve(sampleLadder(), side, sizes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, size := range sizes {
+			want, err := EffectivePrice(sampleLadder(), side, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !approx(prices[i], want) {
+				t.Errorf("side %v size %v: price = %v, want %v", side, size, prices[i], want)
+			}
+		}
+	}
+
+	if _, err := PriceForSizeCurve(sampleLadder(), Bid, []float64{5, 18}); !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("err = %v, want ErrInsufficientLiquidity", err)
+	}
+}
+
+func curveSizes() []float64 {
+	sizes := make([]float64, 400)
+	for i := range sizes {
+		sizes[i] = float64(i + 1)
+	}
+	return sizes
+}
+
+func BenchmarkPriceForSizeCurve(b *testing.B) {
+	ladder, _ := GenerateLadder(100, 500, 0.01, 1)
+	sizes := curveSizes()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := PriceForSizeCurve(ladder, Bid, sizes); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEffectivePriceLoop(b *testing.B) {
+	ladder, _ := GenerateLadder(100, 500, 0.01, 1)
+	sizes := curveSizes()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		prices := make([]float64, 0, len(sizes))
+		for _, size := range sizes {
+			price, err := EffectivePrice(ladder, Bid, size)
+			if err != nil {
+				b.Fatal(err)
+			}
+			prices = append(prices, price)
+		}
+	}
+}
+
+func TestMidpointImprovementBps(t *testing.T) {
+	// The sample mid is 22.5
+	for _, c := range []struct {
+		side Side
+		size float64
+		want float64
+	}{
+		{side: Bid, size: 10, want: 1000},   // Buying at 25
+		{side: Bid, size: 15, want: 1562.5}, // Buying at 400/15
+		{side: Ask, size: 10, want: 1250},   // Selling at 20
+	} {
+		got, err := MidpointImprovementBps(sampleLadder(), c.side, c.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !approx(got, c.want) {
+			t.Errorf("side %v, size %v: improvement = %v bps, want %v", c.side, c.size, got, c.want)
+		}
+	}
+
+	oneSided := sampleLadder()
+	oneSided.Bids = nil
+	if _, err := MidpointImprovementBps(oneSided, Bid, 1); err == nil {
+		t.Error("a book with no bids has a midpoint")
+	}
+}
+
+func TestFormatSolUsdc(t *testing.T) {
+	for _, c := range []struct {
+		q       Quote
+		in, out string
+	}{
+		// Buy 12.5 USDC of SOL; base rounds to 9 decimals
+		{q: Quote{InAmount: 12.5, OutAmount: 0.4995002498750624, AToB: true}, in: "12.5", out: "0.49950025"},
+		// Sell 2 SOL; quote rounds to 6 decimals
+		{q: Quote{InAmount: 2, OutAmount: 39.98000049}, in: "2", out: "39.98"},
+		{q: Quote{InAmount: 1e-9, OutAmount: 0.0000004}, in: "0.000000001", out: "0"},
+	} {
+		in, out := c.q.Format(9, 6)
+		if in != c.in || out != c.out {
+			t.Errorf("%+v formats as %q, %q; want %q, %q", c.q, in, out, c.in, c.out)
+		}
+	}
+}
+
+func TestMaxDistinctLevelsAggregatesRepeatedPrices(t *testing.T) {
+	// Two orders resting at 25 make one price level
+	ladder := func() *UiLadder {
+		l := sampleLadder()
+		l.Asks = []UiLadderLevel{{Price: 25, Quantity: 4}, {Price: 25, Quantity: 6}, {Price: 30, Quantity: 5}, {Price: 35, Quantity: 2}}
+		return l
+	}
+	h := sampleHoenix()
+	for _, c := range []struct {
+		levels int
+		out    float64
+	}{
+		{levels: 1, out: 10}, // Both orders at 25, not just the first
+		{levels: 2, out: 15}, // 25 and 30; the 35 level is left
+	} {
+		q, _, err := h.GetQuote(QuoteParams{InAmount: 1000, AToB: true, MaxDistinctLevels: c.levels}, ladder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !q.Partial || !approx(q.OutAmount, c.out) {
+			t.Errorf("%d levels: quote = %v out, partial %v; want %v, partial", c.levels, q.OutAmount, q.Partial, c.out)
+		}
+		if len(q.Fills) != c.levels+1 {
+			t.Errorf("%d levels: %d fills, want %d", c.levels, len(q.Fills), c.levels+1)
+		}
+	}
+}
+
+func TestMicropriceImbalanceFairValue(t *testing.T) {
+	// Equal tops: the microprice is the mid and the book is balanced
+	balanced := sampleLadder()
+	if micro, ok := balanced.Microprice(); !ok || micro != 22.5 {
+		t.Errorf("balanced microprice = %v, %v; want 22.5", micro, ok)
+	}
+	if imbalance, ok := balanced.Imbalance(3); !ok || imbalance != 0 {
+		t.Errorf("balanced imbalance = %v, %v; want 0", imbalance, ok)
+	}
+
+	// Three times the size on the bid pulls the microprice toward the ask:
+	// (20*10 + 25*30) / 40 = 23.75, and the imbalance is (30-10)/40 = 0.5
+	heavy := &UiLadder{
+		Bids: []UiLadderLevel{{Price: 20, Quantity: 30}},
+		Asks: []UiLadderLevel{{Price: 25, Quantity: 10}},
+	}
+	if micro, ok := heavy.Microprice(); !ok || micro != 23.75 {
+		t.Errorf("microprice = %v, %v; want 23.75", micro, ok)
+	}
+	if imbalance, ok := heavy.Imbalance(1); !ok || imbalance != 0.5 {
+		t.Errorf("imbalance = %v, %v; want 0.5", imbalance, ok)
+	}
+	for _, c := range []struct{ skew, want float64 }{
+		{skew: 0, want: 23.75},
+		{skew: 1, want: 25},    // 23.75 + 0.5 * 2.5
+		{skew: 4, want: 26.25}, // Clamped to one half-spread
+		{skew: -4, want: 21.25},
+	} {
+		if fair, ok := heavy.FairValue(c.skew); !ok || !approx(fair, c.want) {
+			t.Errorf("skew %v: fair value = %v, %v; want %v", c.skew, fair, ok, c.want)
+		}
+	}
+
+	if _, ok := (&UiLadder{Bids: heavy.Bids}).FairValue(1); ok {
+		t.Error("a one-sided book has a fair value")
+	}
+}
+
+func TestMinNotional(t *testing.T) {
+	h := sampleHoenix()
+	h.MinNotional = 50
+	for _, c := range []struct {
+		params QuoteParams
+		below  bool
+	}{
+		{params: QuoteParams{InAmount: 49.9, AToB: true}, below: true},
+		{params: QuoteParams{InAmount: 50, AToB: true}, below: false},
+		{params: QuoteParams{InAmount: 2.4}, below: true},  // 48 at the 20 bid
+		{params: QuoteParams{InAmount: 2.5}, below: false}, // 50 at the 20 bid
+	} {
+		ladder := sampleLadder()
+		_, _, err := h.GetQuote(c.params, ladder)
+		if c.below {
+			if !errors.Is(err, ErrBelowMinNotional) {
+				t.Errorf("%+v: err = %v, want ErrBelowMinNotional", c.params, err)
+			}
+			if !reflect.DeepEqual(ladder, sampleLadder()) {
+				t.Errorf("%+v: a rejected order drained the ladder", c.params)
+			}
+		} else if err != nil {
+			t.Errorf("%+v: %v", c.params, err)
+		}
+	}
+}
+
+func TestConsumedLevels(t *testing.T) {
+	h := sampleHoenix()
+	one := 1
+	for _, c := range []struct {
+		name    string
+		params  QuoteParams
+		fully   []int
+		partial *int
+	}{
+		{name: "buy exactly the top ask", params: QuoteParams{InAmount: 250 * 1.0005, AToB: true}, fully: []int{0}},
+		{name: "buy into the second ask", params: QuoteParams{InAmount: 300, AToB: true}, fully: []int{0}, partial: &one},
+		{name: "sell exactly the top bid", params: QuoteParams{InAmount: 10}, fully: []int{0}},
+		{name: "sell into the second bid", params: QuoteParams{InAmount: 12}, fully: []int{0}, partial: &one},
+	} {
+		q, err := h.GetQuoteReadOnly(c.params, sampleLadder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(q.FullyConsumed, c.fully) || !reflect.DeepEqual(q.PartiallyConsumed, c.partial) {
+			t.Errorf("%s: consumed = %v, %v; want %v, %v", c.name, q.FullyConsumed, q.PartiallyConsumed, c.fully, c.partial)
+		}
+	}
+}
+
+func TestPassiveEffectivePrice(t *testing.T) {
+	for _, c := range []struct {
+		side      Side
+		rebateBps float64
+		want      float64
+	}{
+		{side: Bid, rebateBps: 2, want: 19.996},  // A resting bid at 20 pays less
+		{side: Ask, rebateBps: 2, want: 25.005},  // A resting ask at 25 receives more
+		{side: Bid, rebateBps: -3, want: 20.006}, // A maker fee works the other way
+		{side: Ask, rebateBps: 0, want: 25},
+	} {
+		price := 20.0
+		if c.side == Ask {
+			price = 25
+		}
+		if got := PassiveEffectivePrice(price, c.rebateBps, c.side); !approx(got, c.want) {
+			t.Errorf("side %v at %v, rebate %v bps: price = %v, want %v", c.side, price, c.rebateBps, got, c.want)
+		}
+	}
+}
+
+func TestGetQuoteMultiPriorityAtEqualPrice(t *testing.T) {
+	// Both sources rest 25 asks; the lower priority value fills first
+	limit := &UiLadder{
+		Bids: []UiLadderLevel{{Price: 20, Quantity: 10}},
+		Asks: []UiLadderLevel{{Price: 25, Quantity: 4}, {Price: 30, Quantity: 5}},
+	}
+	postOnly := &UiLadder{
+		Bids: []UiLadderLevel{{Price: 20, Quantity: 10}},
+		Asks: []UiLadderLevel{{Price: 25, Quantity: 6}},
+	}
+	h := sampleHoenix()
+
+	q, _, err := h.GetQuoteMulti(QuoteParams{InAmount: 100 * 1.0005, AToB: true}, []*UiLadder{limit, postOnly}, []int{1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(q.OutAmount, 4) {
+		t.Errorf("out = %v, want 4", q.OutAmount)
+	}
+	if !approx(postOnly.Asks[0].Quantity, 2) || limit.Asks[0].Quantity != 4 {
+		t.Errorf("25 asks left = %v (priority 0), %v (priority 1); want 2, 4", postOnly.Asks[0].Quantity, limit.Asks[0].Quantity)
+	}
+	if limit.LadderVersion != 1 || postOnly.LadderVersion != 1 {
+		t.Errorf("versions = %d, %d; want both bumped", limit.LadderVersion, postOnly.LadderVersion)
+	}
+
+	// A nil source is skipped rather than dereferenced
+	q, _, err = h.GetQuoteMulti(QuoteParams{InAmount: 50 * 1.0005, AToB: true}, []*UiLadder{limit, nil}, []int{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(q.OutAmount, 2) || !approx(limit.Asks[0].Quantity, 2) || limit.LadderVersion != 2 {
+		t.Errorf("with a nil ladder: out = %v, left %v at version %d; want 2, 2, 2", q.OutAmount, limit.Asks[0].Quantity, limit.LadderVersion)
+	}
+}
+
+func TestWorstCasePrice(t *testing.T) {
+	for _, c := range []struct {
+		side             Side
+		minSize, maxSize float64
+		want             float64
+	}{
+		{side: Bid, minSize: 1, maxSize: 10, want: 25},         // Within the top ask
+		{side: Bid, minSize: 1, maxSize: 15, want: 400.0 / 15}, // 10 at 25 and 5 at 30
+		{side: Ask, minSize: 5, maxSize: 15, want: 275.0 / 15}, // 10 at 20 and 5 at 15
+	} {
+		got, err := WorstCasePrice(sampleLadder(), c.side, c.minSize, c.maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !approx(got, c.want) {
+			t.Errorf("side %v, sizes %v to %v: worst price = %v, want %v", c.side, c.minSize, c.maxSize, got, c.want)
+		}
+		// No size in the range sweeps at a worse price
+		for size := c.minSize; size <= c.maxSize; size++ {
+			price, err := EffectivePrice(sampleLadder(), c.side, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (c.side == Bid && price > got+1e-9) || (c.side == Ask && price < got-1e-9) {
+				t.Errorf("side %v: size %v sweeps at %v, worse than %v", c.side, size, price, got)
+			}
+		}
+	}
+
+	if _, err := WorstCasePrice(sampleLadder(), Bid, 5, 1); err == nil {
+		t.Error("an empty size range was accepted")
+	}
+	if _, err := WorstCasePrice(sampleLadder(), Bid, 1, 100); !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("beyond the book: err = %v, want ErrInsufficientLiquidity", err)
+	}
+}
+
+func TestFlatAndTakerFeesBothDeducted(t *testing.T) {
+	h := sampleHoenix()
+
+	// Buy: the flat fee comes off the input, then 5 bps on top of the rest
+	buy, _, err := h.GetQuote(QuoteParams{InAmount: 101, AToB: true, FlatFee: 1}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 100 / 1.0005 / 25; !approx(buy.OutAmount, want) || !approx(buy.FeePaid, 100-100/1.0005) || buy.FlatFee != 1 {
+		t.Errorf("buy = %v out, %v fee, %v flat; want %v, %v, 1", buy.OutAmount, buy.FeePaid, buy.FlatFee, want, 100-100/1.0005)
+	}
+
+	// Sell: 5 bps out of the proceeds, then the flat fee
+	sell, _, err := h.GetQuote(QuoteParams{InAmount: 5, FlatFee: 1}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 100*0.9995 - 1; !approx(sell.OutAmount, want) || !approx(sell.FeePaid, 0.05) || sell.FlatFee != 1 {
+		t.Errorf("sell = %v out, %v fee, %v flat; want %v, 0.05, 1", sell.OutAmount, sell.FeePaid, sell.FlatFee, want)
+	}
+}
+
+func TestResilienceDeepVsShallow(t *testing.T) {
+	deep, _ := GenerateLadder(100, 10, 0.1, 100)
+	shallow, _ := GenerateLadder(100, 10, 0.1, 1)
+	for _, side := range []Side{Bid, Ask} {
+		deepSize, err := deep.Resilience(side, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shallowSize, err := shallow.Resilience(side, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shallowSize <= 0 || !approx(deepSize, 100*shallowSize) {
+			t.Errorf("side %v: deep = %v, shallow = %v; want deep 100 times shallow", side, deepSize, shallowSize)
+		}
+	}
+}
+
+func TestResilienceSide(t *testing.T) {
+	// A buyer sweeps the asks: 25 and 30 lie within 2500 bps of the best ask,
+	// 35 does not. A seller sweeps the bids, where 15 is exactly 2500 bps away.
+	for _, c := range []struct {
+		side Side
+		want float64
+	}{
+		{side: Bid, want: 15},
+		{side: Ask, want: 10},
+	} {
+		size, err := sampleLadder().Resilience(c.side, 2500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != c.want {
+			t.Errorf("side %v: resilience = %v, want %v", c.side, size, c.want)
+		}
+	}
+}
+
+func TestGetQuoteReadOnly(t *testing.T) {
+	h, ladder := sampleHoenix(), sampleLadder()
+	params := QuoteParams{InAmount: 300, AToB: true}
+
+	first, err := h.GetQuoteReadOnly(params, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		again, err := h.GetQuoteReadOnly(params, ladder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(again, first) {
+			t.Errorf("repeat quote = %+v, want %+v", again, first)
+		}
+	}
+	if !reflect.DeepEqual(ladder, sampleLadder()) {
+		t.Errorf("ladder = %+v, want it unchanged", ladder)
+	}
+}
+
+func TestRoundToLotsExplicitSizes(t *testing.T) {
+	h := &Hoenix{}
+	h.Data.Header.BaseParams.Decimals = 9
+	h.Data.Header.QuoteParams.Decimals = 6
+
+	// Derived from the header: one lot per whole base token and one quote atom
+	buy, sell := &Quote{AToB: true, OutAmount: 5.99}, &Quote{OutAmount: 19.987654321}
+	h.RoundToLots(buy)
+	h.RoundToLots(sell)
+	if buy.OutAmount != 5 || !approx(sell.OutAmount, 19.987654) {
+		t.Errorf("derived lots: buy = %v, sell = %v; want 5, 19.987654", buy.OutAmount, sell.OutAmount)
+	}
+
+	// Explicit lots of 0.1 base and 0.01 quote
+	h.BaseLotSize, h.QuoteLotSize = 1e8, 1e4
+	buy, sell = &Quote{AToB: true, OutAmount: 5.99}, &Quote{OutAmount: 19.987654321}
+	h.RoundToLots(buy)
+	h.RoundToLots(sell)
+	if !approx(buy.OutAmount, 5.9) || !approx(sell.OutAmount, 19.98) {
+		t.Errorf("explicit lots: buy = %v, sell = %v; want 5.9, 19.98", buy.OutAmount, sell.OutAmount)
+	}
+}
+
+func TestMakerQuotes(t *testing.T) {
+	// A 100 bps spread fits inside the 20/25 market around the 22.5 mid
+	bid, ask, err := MakerQuotes(sampleLadder(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(bid, 22.3875) || !approx(ask, 22.6125) {
+		t.Errorf("quotes = %v/%v, want 22.3875/22.6125", bid, ask)
+	}
+
+	// A spread wider than the market joins the top of book
+	if bid, ask, err = MakerQuotes(sampleLadder(), 5000); err != nil {
+		t.Fatal(err)
+	}
+	if bid != 20 || ask != 25 {
+		t.Errorf("quotes = %v/%v, want 20/25", bid, ask)
+	}
+}
+
+func TestPriceImpactBP(t *testing.T) {
+	h := sampleHoenix()
+
+	// 150 quote stays within the 25 level
+	q, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 150, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.PriceImpactBP != 0 {
+		t.Errorf("impact within the top level = %d, want 0", q.PriceImpactBP)
+	}
+
+	// 300 quote takes all of the 25 level and the rest at 30
+	q, err = h.GetQuoteReadOnly(QuoteParams{InAmount: 300, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tradable := 300 / 1.0005
+	base := 10 + (tradable-250)/30
+	vwap := tradable / base
+	if want := uint((vwap - 25) / 25 * 10_000); q.PriceImpactBP != want {
+		t.Errorf("impact = %d, want %d", q.PriceImpactBP, want)
+	}
+}
+
+func TestThinBookHaircut(t *testing.T) {
+	h := sampleHoenix()
+	plain, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 400, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 150, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ThinBookHaircut = func(depthRatio float64) float64 {
+		if depthRatio > 0.5 {
+			return 0.1
+		}
+		return 0
+	}
+	// 400 quote buys almost 15 of the 17 base on offer
+	large, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 400, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(large.OutAmount, plain.OutAmount*0.9) {
+		t.Errorf("large order out = %v, want %v", large.OutAmount, plain.OutAmount*0.9)
+	}
+	// 150 quote buys 6 base, about a third of the depth
+	haircutSmall, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 150, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if haircutSmall.OutAmount != small.OutAmount {
+		t.Errorf("small order out = %v, want %v", haircutSmall.OutAmount, small.OutAmount)
+	}
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...any) {
+	l.lines = append(l.lines, format)
+}
+
+func TestQuoteIsSilentWithoutLogger(t *testing.T) {
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	_, quoteErr := sampleHoenix().GetQuoteReadOnly(QuoteParams{InAmount: 300, AToB: true}, sampleLadder())
+	os.Stdout = stdout
+	w.Close()
+	output, _ := io.ReadAll(r)
+
+	if quoteErr != nil {
+		t.Fatal(quoteErr)
+	}
+	if len(output) > 0 {
+		t.Errorf("quote printed %q, want no output", output)
+	}
+
+	h := sampleHoenix()
+	logger := &recordingLogger{}
+	h.Logger = logger
+	if _, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 300, AToB: true}, sampleLadder()); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.lines) == 0 {
+		t.Error("configured logger received no diagnostics")
+	}
+}
+
+func BenchmarkGetQuoteReadOnly(b *testing.B) {
+	h := sampleHoenix()
+	ladder := sampleLadder()
+	params := QuoteParams{InAmount: 300, AToB: true}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.GetQuoteReadOnly(params, ladder); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBuildUiLadder(t *testing.T) {
+	h := &Hoenix{}
+	h.Data.Header.BaseParams.Decimals = 9
+	h.Data.Header.QuoteParams.Decimals = 6
+	h.Data.Header.RawBaseUnitsPerBaseUnit = 1000 // A base lot is a thousandth of a token
+	h.Data.Bids = map[string]RawOrder{
+		"a": {PriceInTicks: 20_000_000, NumBaseLots: 3000},
+		"b": {PriceInTicks: 21_000_000, NumBaseLots: 2000},
+		"c": {PriceInTicks: 20_000_000, NumBaseLots: 1000},
+	}
+	h.Data.Asks = map[string]RawOrder{
+		"x": {PriceInTicks: 25_000_000, NumBaseLots: 5000},
+		"y": {PriceInTicks: 23_500_000, NumBaseLots: 500},
+	}
+
+	ladder, err := h.BuildUiLadder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &UiLadder{
+		Bids: []UiLadderLevel{{Price: 21, Quantity: 2}, {Price: 20, Quantity: 4}},
+		Asks: []UiLadderLevel{{Price: 23.5, Quantity: 0.5}, {Price: 25, Quantity: 5}},
+	}
+	if !reflect.DeepEqual(ladder, want) {
+		t.Errorf("ladder = %+v, want %+v", ladder, want)
+	}
+}
+
+func TestShapeStats(t *testing.T) {
+	// A buyer sweeps the asks, 101 to 105
+	uniform, _ := GenerateLadder(100, 5, 1, 10)
+	mean, stddev, share := uniform.ShapeStats(Bid)
+	if !approx(mean, 103) || !approx(stddev, math.Sqrt(2)) || !approx(share, 0.2) {
+		t.Errorf("uniform book: mean %v, stddev %v, share %v; want 103, sqrt(2), 0.2", mean, stddev, share)
+	}
+
+	wall := &UiLadder{Asks: []UiLadderLevel{{Price: 10, Quantity: 1}, {Price: 11, Quantity: 1}, {Price: 12, Quantity: 98}}}
+	if _, _, share := wall.ShapeStats(Bid); !approx(share, 0.98) {
+		t.Errorf("wall share = %v, want 0.98", share)
+	}
+	if mean, _, _ := wall.ShapeStats(Ask); mean != 0 {
+		t.Errorf("a seller sees bids, not the asks: mean %v, want 0", mean)
+	}
+}
+
+func TestBuildUiLadderDropsExpiredOrders(t *testing.T) {
+	h := sampleHoenix()
+	h.Clock = ClockData{Slot: 100, UnixTimestamp: 1_700_000_000}
+	h.Data.Header.QuoteParams.Decimals = 0
+	h.Data.Bids = map[string]RawOrder{"bid": {NumBaseLots: 10, PriceInTicks: 20}}
+	h.Data.Asks = map[string]RawOrder{
+		"expired": {LastValidSlot: 90, NumBaseLots: 10, PriceInTicks: 22},
+		"stale":   {LastValidUnixTimestampInSeconds: 1_600_000_000, NumBaseLots: 10, PriceInTicks: 23},
+		"live":    {LastValidSlot: 200, NumBaseLots: 10, PriceInTicks: 25},
+	}
+
+	ladder, err := h.BuildUiLadder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ladder.Asks) != 1 || ladder.Asks[0].Price != 25 {
+		t.Fatalf("asks = %+v, want only the live order at 25", ladder.Asks)
+	}
+	q, _, err := h.GetQuote(QuoteParams{InAmount: 100, AToB: true}, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Fills) != 1 || q.Fills[0].Price != 25 {
+		t.Errorf("fills = %+v, want one fill at 25", q.Fills)
+	}
+}
+
+func TestExcludePrices(t *testing.T) {
+	ladder := sampleLadder()
+	q, _, err := sampleHoenix().GetQuote(QuoteParams{InAmount: 100, AToB: true, ExcludePrices: []float64{25 + 1e-12}}, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Fills) != 1 || q.Fills[0].Price != 30 || q.Fills[0].Level != 1 {
+		t.Errorf("fills = %+v, want one fill at 30 on level 1", q.Fills)
+	}
+	if ladder.Asks[0].Quantity != 10 {
+		t.Errorf("excluded level holds %v, want it untouched", ladder.Asks[0].Quantity)
+	}
+}
+
+func TestGetQuoteExactOut(t *testing.T) {
+	h, ladder := sampleHoenix(), sampleLadder()
+
+	// 12 base costs 10 at 25 and 2 at 30, plus the fee
+	buy, err := h.GetQuoteExactOut(12, true, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(buy.InAmount, 310*1.0005) || !approx(buy.FeePaid, 310*0.0005) {
+		t.Errorf("buy = %+v, want 310.155 in with 0.155 fee", buy)
+	}
+	back, err := h.GetQuoteReadOnly(QuoteParams{InAmount: buy.InAmount, AToB: true}, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(back.OutAmount, 12) {
+		t.Errorf("GetQuote of the exact-out input = %v, want 12", back.OutAmount)
+	}
+
+	// 150 quote of proceeds needs 150.075 before the fee, all at the 20 bid
+	sell, err := h.GetQuoteExactOut(150, false, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 150 / 0.9995 / 20; !approx(sell.InAmount, want) {
+		t.Errorf("sell in = %v, want %v", sell.InAmount, want)
+	}
+	if back, err = h.GetQuoteReadOnly(QuoteParams{InAmount: sell.InAmount}, ladder); err != nil {
+		t.Fatal(err)
+	}
+	if !approx(back.OutAmount, 150) {
+		t.Errorf("GetQuote of the exact-out input = %v, want 150", back.OutAmount)
+	}
+
+	if _, err := h.GetQuoteExactOut(18, true, ladder); !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("err = %v, want ErrInsufficientLiquidity", err)
+	}
+	if !reflect.DeepEqual(ladder, sampleLadder()) {
+		t.Error("GetQuoteExactOut modified the ladder")
+	}
+}
+
+func TestMaxBookAgeSlots(t *testing.T) {
+	h := sampleHoenix()
+	h.Clock.Slot = 1000
+	h.MaxBookAgeSlots = 10
+
+	fresh := sampleLadder()
+	fresh.Asks[0].LastValidSlot = 995
+	if _, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 100, AToB: true}, fresh); err != nil {
+		t.Errorf("fresh book: %v", err)
+	}
+
+	stale := sampleLadder()
+	stale.Asks[0].LastValidSlot = 900
+	if _, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 100, AToB: true}, stale); !errors.Is(err, ErrBookStale) {
+		t.Errorf("stale book: err = %v, want ErrBookStale", err)
+	}
+}
+
+func TestQuoteReceiptRoundTrip(t *testing.T) {
+	h := sampleHoenix()
+	h.Clock = ClockData{Slot: 42, UnixTimestamp: 1_700_000_000}
+	_, _, receipt, err := h.GetQuoteWithReceipt(QuoteParams{InAmount: 300, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded QuoteReceipt
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Ladder, *sampleLadder()) {
+		t.Errorf("receipt ladder = %+v, want the book before the quote", decoded.Ladder)
+	}
+	if _, err := ReplayQuote(decoded); err != nil {
+		t.Errorf("replay: %v", err)
+	}
+
+	decoded.Quote.OutAmount++
+	if _, err := ReplayQuote(decoded); !errors.Is(err, ErrReceiptMismatch) {
+		t.Errorf("tampered replay: err = %v, want ErrReceiptMismatch", err)
+	}
+}
+
+func TestInsufficientLiquidityIsTyped(t *testing.T) {
+	ladder := sampleLadder()
+	_, _, err := sampleHoenix().GetQuote(QuoteParams{InAmount: 10_000, AToB: true}, ladder)
+	if !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("err = %v, want ErrInsufficientLiquidity", err)
+	}
+	if !reflect.DeepEqual(ladder, sampleLadder()) {
+		t.Error("a failed quote modified the ladder")
+	}
+}
+
+func TestMinOutAmount(t *testing.T) {
+	h := sampleHoenix()
+	// 150 quote buys 150/1.0005/25, just under 6 base
+	if _, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 150, AToB: true, MinOutAmount: 5.9}, sampleLadder()); err != nil {
+		t.Errorf("satisfied bound: %v", err)
+	}
+
+	_, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 150, AToB: true, MinOutAmount: 6}, sampleLadder())
+	var slippage *SlippageError
+	if !errors.Is(err, ErrSlippageExceeded) || !errors.As(err, &slippage) {
+		t.Fatalf("err = %v, want a *SlippageError", err)
+	}
+	if slippage.MinOutAmount != 6 || !approx(slippage.OutAmount, 150/1.0005/25) {
+		t.Errorf("slippage error = %+v", slippage)
+	}
+}
+
+func TestFillsBreakdown(t *testing.T) {
+	q, err := sampleHoenix().GetQuoteReadOnly(QuoteParams{InAmount: 300, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Fills) != 2 || q.Fills[0].Price != 25 || q.Fills[1].Price != 30 {
+		t.Fatalf("fills = %+v, want fills at 25 and 30", q.Fills)
+	}
+	base, quote := 0.0, 0.0
+	for _, fill := range q.Fills {
+		base += fill.BaseFilled
+		quote += fill.QuoteFilled
+	}
+	if !approx(base, q.OutAmount) || !approx(quote, 300/1.0005) {
+		t.Errorf("fills sum to %v base for %v quote, want %v for %v", base, quote, q.OutAmount, 300/1.0005)
+	}
+}
+
+func TestAverageFillPrice(t *testing.T) {
+	q, err := sampleHoenix().GetQuoteReadOnly(QuoteParams{InAmount: 300, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	price, err := q.AverageFillPrice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// VWAP of the fills, with the fee on top
+	vwap := (250 + 30*q.Fills[1].BaseFilled) / (10 + q.Fills[1].BaseFilled) * 1.0005
+	if !approx(price, vwap) {
+		t.Errorf("average price = %v, want %v", price, vwap)
+	}
+
+	if _, err := (&Quote{}).AverageFillPrice(); err == nil {
+		t.Error("expected an error for an empty quote")
+	}
+}
+
+func TestTakerFeeLeg(t *testing.T) {
+	h := sampleHoenix()
+
+	// Buys pay the fee on top of the quote input
+	buy, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 150, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(buy.OutAmount, 150/1.0005/25) || !approx(buy.FeePaid, 150-150/1.0005) {
+		t.Errorf("buy = %+v", buy)
+	}
+
+	// Sells sweep the whole base input and pay the fee out of the proceeds
+	sell, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 5}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sell.Fills[0].BaseFilled != 5 || !approx(sell.OutAmount, 99.95) || !approx(sell.FeePaid, 0.05) {
+		t.Errorf("sell = %+v, want 5 base for 99.95 after a 0.05 fee", sell)
+	}
+}
+
+func TestZeroPriceLevelIsRejected(t *testing.T) {
+	ladder := sampleLadder()
+	ladder.Asks[0].Price = 0
+	q, _, err := sampleHoenix().GetQuote(QuoteParams{InAmount: 100, AToB: true}, ladder)
+	if err == nil || !strings.Contains(err.Error(), "asks: level 0: invalid price") {
+		t.Fatalf("quote = %+v, err = %v; want an invalid price error for ask 0", q, err)
+	}
+}
+
+func TestLadderIndexMatchesSweep(t *testing.T) {
+	h := &Hoenix{}
+	ladder, _ := GenerateLadder(100, 50, 0.5, 3)
+	index := NewLadderIndex(ladder)
+	for _, budget := range []float64{1, 300, 301.5, 4_000, 16_000} {
+		got, err := index.QuoteBaseOut(budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := h.calculateBaseAmountFromQuoteBudget(ladder.Asks, budget, sweepOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !approx(got, want.amount) {
+			t.Errorf("budget %v: indexed = %v, sweep = %v", budget, got, want.amount)
+		}
+	}
+
+	if _, err := index.QuoteBaseOut(1e9); !errors.Is(err, ErrInsufficientLiquidity) {
+		t.Errorf("err = %v, want ErrInsufficientLiquidity", err)
+	}
+}
+
+func BenchmarkLinearSweep500(b *testing.B) {
+	h := &Hoenix{}
+	ladder, _ := GenerateLadder(1000, 500, 0.5, 1)
+	for i := 0; i < b.N; i++ {
+		if _, err := h.calculateBaseAmountFromQuoteBudget(ladder.Asks, 400_000, sweepOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLadderIndex500(b *testing.B) {
+	ladder, _ := GenerateLadder(1000, 500, 0.5, 1)
+	index := NewLadderIndex(ladder)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := index.QuoteBaseOut(400_000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	ladder := sampleLadder()
+	ladder.Asks[1].QueueAhead = 1.5
+	ladder.Asks[2].Denom = DenomQuote
+	ladder.Bids[0].LastValidSlot = 77
+	ladder.LadderVersion = 3
+
+	data, err := json.Marshal(ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"asks"`, `"bids"`, `"price"`, `"quantity"`, `"ladderVersion":3`} {
+		if !bytes.Contains(data, []byte(key)) {
+			t.Errorf("ladder JSON %s has no %s key", data, key)
+		}
+	}
+	var decoded UiLadder
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, ladder) {
+		t.Errorf("decoded ladder = %+v, want %+v", decoded, *ladder)
+	}
+
+	q, err := sampleHoenix().GetQuoteReadOnly(QuoteParams{InAmount: 300, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err = json.Marshal(q); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"inAmount"`, `"outAmount"`, `"priceImpactBp"`} {
+		if !bytes.Contains(data, []byte(key)) {
+			t.Errorf("quote JSON %s has no %s key", data, key)
+		}
+	}
+	var decodedQuote Quote
+	if err := json.Unmarshal(data, &decodedQuote); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decodedQuote, q) {
+		t.Errorf("decoded quote = %+v, want %+v", decodedQuote, *q)
+	}
+}
+
+func TestTopOfBook(t *testing.T) {
+	ladder := sampleLadder()
+	bid, okBid := ladder.BestBid()
+	ask, okAsk := ladder.BestAsk()
+	mid, okMid := ladder.MidPrice()
+	spread, okSpread := ladder.SpreadBps()
+	if !okBid || !okAsk || !okMid || !okSpread {
+		t.Fatal("expected every accessor to succeed on the sample book")
+	}
+	if bid != 20 || ask != 25 || mid != 22.5 || !approx(spread, 5/22.5*10_000) {
+		t.Errorf("bid %v, ask %v, mid %v, spread %v", bid, ask, mid, spread)
+	}
+
+	ladder.Asks = nil
+	if _, ok := ladder.MidPrice(); ok {
+		t.Error("mid price of a one-sided book should not be ok")
+	}
+	if _, ok := ladder.SpreadBps(); ok {
+		t.Error("spread of a one-sided book should not be ok")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := sampleLadder().Validate(); err != nil {
+		t.Errorf("healthy book: %v", err)
+	}
+
+	crossed := sampleLadder()
+	crossed.Bids[0].Price = 26
+	if err := crossed.Validate(); err == nil {
+		t.Error("expected an error for a crossed book")
+	}
+	if _, _, err := sampleHoenix().GetQuote(QuoteParams{InAmount: 100, AToB: true, ValidateBook: true}, crossed); err == nil {
+		t.Error("GetQuote with ValidateBook accepted a crossed book")
+	}
+
+	unsorted := sampleLadder()
+	unsorted.Asks[0], unsorted.Asks[1] = unsorted.Asks[1], unsorted.Asks[0]
+	if err := unsorted.Validate(); err == nil {
+		t.Error("expected an error for unsorted asks")
+	}
+}
+
+func TestSellDrainsFilledBase(t *testing.T) {
+	h, ladder := sampleHoenix(), sampleLadder()
+	// 12 base empties the 20 bid and takes 2 of the 15s, fee aside
+	if _, _, err := h.GetQuote(QuoteParams{InAmount: 12}, ladder); err != nil {
+		t.Fatal(err)
+	}
+	if base, quote := ladder.TotalBidDepth(); !approx(base, 5) || !approx(quote, 65) {
+		t.Errorf("bid depth = %v base, %v quote; want 5, 65", base, quote)
+	}
+
+	q, _, err := h.GetQuote(QuoteParams{InAmount: 5}, ladder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Fills) != 2 || q.Fills[0].Price != 15 || !approx(q.Fills[0].BaseFilled, 3) || q.Fills[1].Price != 10 {
+		t.Errorf("fills = %+v, want 3 at 15 then 2 at 10", q.Fills)
+	}
+}
+
+func TestTotalDepth(t *testing.T) {
+	ladder := sampleLadder()
+	if base, quote := ladder.TotalAskDepth(); base != 17 || quote != 470 {
+		t.Errorf("ask depth = %v, %v; want 17, 470", base, quote)
+	}
+
+	// Exactly the 25 level, fee on top
+	if _, _, err := sampleHoenix().GetQuote(QuoteParams{InAmount: 250 * 1.0005, AToB: true}, ladder); err != nil {
+		t.Fatal(err)
+	}
+	if base, quote := ladder.TotalAskDepth(); !approx(base, 7) || !approx(quote, 220) {
+		t.Errorf("ask depth = %v, %v; want 7, 220", base, quote)
+	}
+	if base, quote := ladder.TotalBidDepth(); base != 17 || quote != 295 {
+		t.Errorf("bid depth = %v, %v; want 17, 295", base, quote)
+	}
+}
+
+func TestAllowPartial(t *testing.T) {
+	q, err := sampleHoenix().GetQuoteReadOnly(QuoteParams{InAmount: 10_000, AToB: true, AllowPartial: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.Partial || !approx(q.OutAmount, 17) {
+		t.Errorf("quote = %+v, want a partial fill of all 17 base", q)
+	}
+	if !approx(q.Filled, 470*1.0005) || q.Filled != q.InAmount || q.Requested != 10_000 {
+		t.Errorf("filled %v of %v, in %v; want 470.235 of 10000", q.Filled, q.Requested, q.InAmount)
+	}
+}
+
+func TestGenerateLadder(t *testing.T) {
+	ladder, err := GenerateLadder(100, 20, 0.25, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ladder.Validate(); err != nil {
+		t.Errorf("generated book fails Validate: %v", err)
+	}
+	if len(ladder.Bids) != 20 || len(ladder.Asks) != 20 {
+		t.Errorf("levels = %d bids, %d asks; want 20 each", len(ladder.Bids), len(ladder.Asks))
+	}
+	if ladder.Bids[0].Price != 99.75 || ladder.Asks[0].Price != 100.25 || ladder.Asks[19].Price != 105 {
+		t.Errorf("prices = %v, %v, %v", ladder.Bids[0].Price, ladder.Asks[0].Price, ladder.Asks[19].Price)
+	}
+
+	if _, err := GenerateLadder(1, 20, 0.25, 3); err == nil {
+		t.Error("expected an error for bids at or below zero")
+	}
+}
+
+func TestGetQuoteContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ladder := sampleLadder()
+	_, _, err := sampleHoenix().GetQuoteContext(ctx, QuoteParams{InAmount: 300, AToB: true}, ladder)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if !reflect.DeepEqual(ladder, sampleLadder()) {
+		t.Error("a canceled quote modified the ladder")
+	}
+}
+
+func TestFeePaid(t *testing.T) {
+	h := sampleHoenix()
+	buy, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 150, AToB: true}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 150 - h.applyTakerFee(150, 5); !approx(buy.FeePaid, want) {
+		t.Errorf("buy fee = %v, want %v", buy.FeePaid, want)
+	}
+
+	sell, err := h.GetQuoteReadOnly(QuoteParams{InAmount: 5}, sampleLadder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(sell.FeePaid, 100*5.0/10_000) {
+		t.Errorf("sell fee = %v, want 0.05", sell.FeePaid)
+	}
+}
@@ -0,0 +1,309 @@
+// Package venue defines what a router needs from a trading venue, so Phoenix
+// and Lifinity can be quoted side by side without special-casing either.
+package venue
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// MaxDecimals is the most decimals an Amount can carry: 10^18 is the largest
+// power of ten an int64 holds.
+const MaxDecimals = 18
+
+// ErrAmountOverflow is returned when an Amount's mantissa would not fit in an
+// int64.
+var ErrAmountOverflow = errors.New("amount overflows int64")
+
+// Amount is an exact token amount, Mantissa * 10^-Decimals. Decimals is
+// usually the token's own, so Mantissa counts its smallest units: 1.5 SOL is
+// {1_500_000_000, 9} and 1.5 USDC is {1_500_000, 6}. Amounts with different
+// decimals can be combined; results take the larger of the two.
+type Amount struct {
+	Mantissa int64
+	Decimals int
+}
+
+// FromFloat converts f to an Amount with the given decimals, rounding to the
+// nearest unit. Floats carry about 15 significant digits, so a value such as a
+// 9-decimal SOL balance below 10^6 converts without loss.
+func FromFloat(f float64, decimals int) (Amount, error) {
+	if decimals < 0 || decimals > MaxDecimals {
+		return Amount{}, fmt.Errorf("decimals %d must be in [0, %d]", decimals, MaxDecimals)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Amount{}, fmt.Errorf("invalid amount %v", f)
+	}
+	mantissa := math.Round(f * math.Pow10(decimals))
+	if mantissa >= math.MaxInt64 || mantissa < math.MinInt64 {
+		return Amount{}, fmt.Errorf("%w: %v with %d decimals", ErrAmountOverflow, f, decimals)
+	}
+	return Amount{Mantissa: int64(mantissa), Decimals: decimals}, nil
+}
+
+// ToFloat returns a as a float64, which may round amounts beyond 2^53 units.
+func (a Amount) ToFloat() float64 {
+	return float64(a.Mantissa) / math.Pow10(a.Decimals)
+}
+
+// Rescale returns a with the given decimals. Adding decimals is exact;
+// dropping them truncates toward zero.
+func (a Amount) Rescale(decimals int) (Amount, error) {
+	if decimals < 0 || decimals > MaxDecimals {
+		return Amount{}, fmt.Errorf("decimals %d must be in [0, %d]", decimals, MaxDecimals)
+	}
+	return fromBig(scaleBig(a.bigMantissa(), a.Decimals, decimals), decimals)
+}
+
+// Add returns a + b.
+func (a Amount) Add(b Amount) (Amount, error) {
+	decimals := max(a.Decimals, b.Decimals)
+	sum := scaleBig(a.bigMantissa(), a.Decimals, decimals)
+	sum.Add(sum, scaleBig(b.bigMantissa(), b.Decimals, decimals))
+	return fromBig(sum, decimals)
+}
+
+// Sub returns a - b.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	decimals := max(a.Decimals, b.Decimals)
+	diff := scaleBig(a.bigMantissa(), a.Decimals, decimals)
+	diff.Sub(diff, scaleBig(b.bigMantissa(), b.Decimals, decimals))
+	return fromBig(diff, decimals)
+}
+
+// Mul returns a * b with a's decimals, truncated toward zero. Multiplying a
+// base amount by a price in quote per base gives the quote notional in the base
+// amount's decimals; Rescale it to the quote token's decimals as needed.
+func (a Amount) Mul(b Amount) (Amount, error) {
+	product := new(big.Int).Mul(a.bigMantissa(), b.bigMantissa())
+	return fromBig(scaleBig(product, a.Decimals+b.Decimals, a.Decimals), a.Decimals)
+}
+
+// Cmp compares a and b exactly and returns -1, 0 or +1.
+func (a Amount) Cmp(b Amount) int {
+	decimals := max(a.Decimals, b.Decimals)
+	return scaleBig(a.bigMantissa(), a.Decimals, decimals).Cmp(scaleBig(b.bigMantissa(), b.Decimals, decimals))
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of a.
+func (a Amount) Sign() int {
+	switch {
+	case a.Mantissa < 0:
+		return -1
+	case a.Mantissa > 0:
+		return 1
+	}
+	return 0
+}
+
+// String renders a as a plain decimal, e.g. "1.500000000".
+func (a Amount) String() string {
+	digits := new(big.Int).Abs(a.bigMantissa()).String()
+	sign := ""
+	if a.Mantissa < 0 {
+		sign = "-"
+	}
+	if a.Decimals <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= a.Decimals {
+		digits = strings.Repeat("0", a.Decimals-len(digits)+1) + digits
+	}
+	point := len(digits) - a.Decimals
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func (a Amount) bigMantissa() *big.Int {
+	return big.NewInt(a.Mantissa)
+}
+
+// scaleBig moves v from one number of decimals to another, truncating toward
+// zero when decimals are dropped. It may modify v.
+func scaleBig(v *big.Int, from, to int) *big.Int {
+	if to == from {
+		return v
+	}
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(to-from))), nil)
+	if to > from {
+		return v.Mul(v, factor)
+	}
+	return v.Quo(v, factor)
+}
+
+func fromBig(v *big.Int, decimals int) (Amount, error) {
+	if !v.IsInt64() {
+		return Amount{}, fmt.Errorf("%w: %s with %d decimals", ErrAmountOverflow, v, decimals)
+	}
+	return Amount{Mantissa: v.Int64(), Decimals: decimals}, nil
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Quote is a venue-neutral quote. AToB follows the Quoter convention: true
+// spends the base token (A) for the quote token (B).
+type Quote struct {
+	InAmount      Amount
+	OutAmount     Amount
+	AToB          bool
+	FeeBps        float64 // Venue fee charged on the swap, in basis points
+	PriceImpactBP uint    // Price impact in basis points, as the venue reports it
+}
+
+// Quoter prices a swap of in without executing it: quoting never changes the
+// venue's book or reserves. aToB true sells base for quote, false buys base
+// with quote.
+type Quoter interface {
+	Quote(in Amount, aToB bool) (Quote, error)
+}
+
+// Leg is one hop of a Route: a venue and the direction to swap on it.
+type Leg struct {
+	Quoter Quoter
+	AToB   bool
+}
+
+// Route chains legs so that each leg's output is the next leg's input, e.g.
+// A→B on one venue then B→C on another. Adjacent legs must trade the same token
+// between them; Amount carries its decimals, so the venues need not agree on
+// units.
+type Route struct {
+	Legs []Leg
+}
+
+// RouteQuote is the composed quote of a Route. FeeBps and PriceImpactBP are the
+// sums over the legs, a first-order estimate of the route's total cost.
+type RouteQuote struct {
+	InAmount      Amount
+	OutAmount     Amount
+	FeeBps        float64
+	PriceImpactBP uint
+	Legs          []Quote // Quote of each leg, in route order
+}
+
+// LegError reports the leg at which a route could not be quoted.
+type LegError struct {
+	Leg int
+	Err error
+}
+
+func (e *LegError) Error() string {
+	return fmt.Sprintf("leg %d: %v", e.Leg, e.Err)
+}
+
+func (e *LegError) Unwrap() error {
+	return e.Err
+}
+
+// QuoteRoute quotes in through every leg in order. It stops at the first leg
+// that fails, for instance for lack of liquidity, and returns a *LegError.
+func (r Route) QuoteRoute(in Amount) (RouteQuote, error) {
+	if len(r.Legs) == 0 {
+		return RouteQuote{}, errors.New("route has no legs")
+	}
+
+	route := RouteQuote{InAmount: in, Legs: make([]Quote, 0, len(r.Legs))}
+	amount := in
+	for i, leg := range r.Legs {
+		quote, err := leg.Quoter.Quote(amount, leg.AToB)
+		if err != nil {
+			return RouteQuote{}, &LegError{Leg: i, Err: err}
+		}
+		route.Legs = append(route.Legs, quote)
+		route.FeeBps += quote.FeeBps
+		route.PriceImpactBP += quote.PriceImpactBP
+		amount = quote.OutAmount
+	}
+	route.OutAmount = amount
+	return route, nil
+}
+
+// Allocation is the part of a split order sent to one venue.
+type Allocation struct {
+	Venue     int // Index of the venue in the quoters passed to BestSplit
+	InAmount  Amount
+	OutAmount Amount
+}
+
+// BestSplit divides in into steps equal chunks and gives each chunk to the
+// venue whose quote grows the most from taking it, so a large order spreads
+// across venues as their prices worsen. Quoters are never mutated: a venue's
+// marginal output is the difference between quotes for its cumulative
+// allocation with and without the chunk. It returns one Allocation per quoter,
+// in order, and their total output.
+func BestSplit(in Amount, aToB bool, quoters []Quoter, steps int) ([]Allocation, Amount, error) {
+	if in.Sign() <= 0 {
+		return nil, Amount{}, fmt.Errorf("invalid input amount %v", in)
+	}
+	if steps <= 0 {
+		return nil, Amount{}, errors.New("steps must be greater than zero")
+	}
+	if len(quoters) == 0 {
+		return nil, Amount{}, errors.New("no venues to split across")
+	}
+	chunk := Amount{Mantissa: in.Mantissa / int64(steps), Decimals: in.Decimals}
+	if chunk.Sign() == 0 {
+		return nil, Amount{}, fmt.Errorf("input %v is too small to split into %d steps", in, steps)
+	}
+
+	allocations := make([]Allocation, len(quoters))
+	for i := range allocations {
+		allocations[i].Venue = i
+	}
+	remaining := in
+	for step := 0; step < steps; step++ {
+		size := chunk
+		if step == steps-1 {
+			// Absorb the division remainder so the allocations sum to in exactly
+			size = remaining
+		}
+
+		best := -1
+		var bestIn, bestOut, bestGain Amount
+		var lastErr error
+		for i, quoter := range quoters {
+			allocated, err := allocations[i].InAmount.Add(size)
+			if err != nil {
+				return nil, Amount{}, err
+			}
+			quote, err := quoter.Quote(allocated, aToB)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			gain, err := quote.OutAmount.Sub(allocations[i].OutAmount)
+			if err != nil {
+				return nil, Amount{}, err
+			}
+			if best < 0 || gain.Cmp(bestGain) > 0 {
+				best, bestIn, bestOut, bestGain = i, allocated, quote.OutAmount, gain
+			}
+		}
+		if best < 0 {
+			return nil, Amount{}, fmt.Errorf("no venue can fill chunk %d of %d: %w", step+1, steps, lastErr)
+		}
+		allocations[best].InAmount = bestIn
+		allocations[best].OutAmount = bestOut
+
+		var err error
+		if remaining, err = remaining.Sub(size); err != nil {
+			return nil, Amount{}, err
+		}
+	}
+
+	var total Amount
+	for _, allocation := range allocations {
+		var err error
+		if total, err = total.Add(allocation.OutAmount); err != nil {
+			return nil, Amount{}, err
+		}
+	}
+	return allocations, total, nil
+}
@@ -0,0 +1,210 @@
+package venue_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/marccanlas/phoenix-sdk-migration/lifinity"
+	"github.com/marccanlas/phoenix-sdk-migration/phoenix"
+	"github.com/marccanlas/phoenix-sdk-migration/venue"
+)
+
+// solUsdcBook returns a SOL/USDC Phoenix market charging 5 bps, with bids at
+// 20, 15 and 10.
+func solUsdcBook() *phoenix.Hoenix {
+	h := &phoenix.Hoenix{}
+	h.Data.TakerFeeBps = 5
+	h.Data.Header.BaseParams.Decimals = 9
+	h.Data.Header.QuoteParams.Decimals = 6
+	h.Ladder = &phoenix.UiLadder{
+		Bids: []phoenix.UiLadderLevel{{Price: 20, Quantity: 10}, {Price: 15, Quantity: 5}, {Price: 10, Quantity: 2}},
+		Asks: []phoenix.UiLadderLevel{{Price: 25, Quantity: 10}, {Price: 30, Quantity: 5}, {Price: 35, Quantity: 2}},
+	}
+	return h
+}
+
+// solUsdcPool returns a SOL/USDC Lifinity pool holding 1000 SOL and 20000 USDC.
+func solUsdcPool() *lifinity.LifinityLiquidity {
+	pool := lifinity.NewLifinityLiquidity(1000_000_000_000, 20_000_000_000)
+	pool.DecimalsA, pool.DecimalsB = 9, 6
+	return pool
+}
+
+func TestQuotersSideBySide(t *testing.T) {
+	quoters := []venue.Quoter{solUsdcBook(), solUsdcPool()}
+	in := venue.Amount{Mantissa: 5_000_000_000, Decimals: 9}
+
+	var outs []venue.Amount
+	for i, quoter := range quoters {
+		quote, err := quoter.Quote(in, true)
+		if err != nil {
+			t.Fatalf("quoter %d: %v", i, err)
+		}
+		if quote.OutAmount.Decimals != 6 || quote.InAmount.Cmp(in) != 0 {
+			t.Errorf("quoter %d: quote = %+v, want 5 SOL in and USDC out", i, quote)
+		}
+		outs = append(outs, quote.OutAmount)
+	}
+
+	// 5 SOL at the 20 bid less 5 bps
+	if outs[0] != (venue.Amount{Mantissa: 99_950_000, Decimals: 6}) {
+		t.Errorf("book out = %s, want 99.950000", outs[0])
+	}
+	// The pool's 50 bps fee and impact leave it short of the book
+	if outs[1].Sign() <= 0 || outs[1].Cmp(outs[0]) >= 0 {
+		t.Errorf("pool out = %s, want between 0 and %s", outs[1], outs[0])
+	}
+}
+
+func TestQuoteRoute(t *testing.T) {
+	book, pool := solUsdcBook(), solUsdcPool()
+	route := venue.Route{Legs: []venue.Leg{{Quoter: pool, AToB: false}, {Quoter: book, AToB: true}}}
+	in := venue.Amount{Mantissa: 100_000_000, Decimals: 6}
+
+	quote, err := route.QuoteRoute(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quote.Legs) != 2 || quote.InAmount != in {
+		t.Fatalf("route quote = %+v", quote)
+	}
+
+	// Each leg's output feeds the next
+	first, err := pool.Quote(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := book.Quote(first.OutAmount, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Legs[0] != first || quote.OutAmount != second.OutAmount {
+		t.Errorf("route out = %s, want %s", quote.OutAmount, second.OutAmount)
+	}
+	if quote.FeeBps != first.FeeBps+second.FeeBps {
+		t.Errorf("route fee = %v bps, want %v", quote.FeeBps, first.FeeBps+second.FeeBps)
+	}
+	// The round trip through USDC loses the fees and the spread
+	if quote.OutAmount.Cmp(in) >= 0 {
+		t.Errorf("round trip out = %s, want less than %s", quote.OutAmount, in)
+	}
+	if pool.A != 1000_000_000_000 || len(book.Ladder.Bids) != 3 || book.Ladder.Bids[0].Quantity != 10 {
+		t.Error("quoting the route modified a venue")
+	}
+}
+
+func TestQuoteRouteLegError(t *testing.T) {
+	book := solUsdcBook()
+	book.Ladder.Bids = nil
+	route := venue.Route{Legs: []venue.Leg{{Quoter: solUsdcPool(), AToB: false}, {Quoter: book, AToB: true}}}
+
+	_, err := route.QuoteRoute(venue.Amount{Mantissa: 100_000_000, Decimals: 6})
+	var legErr *venue.LegError
+	if !errors.As(err, &legErr) || legErr.Leg != 1 {
+		t.Errorf("err = %v, want a *LegError at leg 1", err)
+	}
+}
+
+func TestBestSplitBeatsEitherVenue(t *testing.T) {
+	quoters := []venue.Quoter{solUsdcBook(), solUsdcPool()}
+	in := venue.Amount{Mantissa: 15_000_000_000, Decimals: 9}
+
+	allocations, total, err := venue.BestSplit(in, true, quoters, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var allocated venue.Amount
+	for _, allocation := range allocations {
+		if allocation.InAmount.Sign() == 0 {
+			t.Errorf("venue %d was given nothing: %+v", allocation.Venue, allocations)
+		}
+		if allocated, err = allocated.Add(allocation.InAmount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if allocated.Cmp(in) != 0 {
+		t.Errorf("allocated %s, want %s", allocated, in)
+	}
+
+	for i, quoter := range quoters {
+		alone, err := quoter.Quote(in, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total.Cmp(alone.OutAmount) <= 0 {
+			t.Errorf("split out = %s, venue %d alone = %s", total, i, alone.OutAmount)
+		}
+	}
+}
+
+func TestAmountTokenDecimals(t *testing.T) {
+	sol, err := venue.FromFloat(1.5, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sol != (venue.Amount{Mantissa: 1_500_000_000, Decimals: 9}) || sol.String() != "1.500000000" || sol.ToFloat() != 1.5 {
+		t.Errorf("1.5 SOL = %+v (%s)", sol, sol)
+	}
+
+	usdc, err := venue.FromFloat(1234.567891, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usdc.Mantissa != 1_234_567_891 || usdc.String() != "1234.567891" {
+		t.Errorf("1234.567891 USDC = %+v (%s)", usdc, usdc)
+	}
+
+	small := venue.Amount{Mantissa: 5, Decimals: 6}
+	if small.String() != "0.000005" {
+		t.Errorf("String() = %s, want 0.000005", small)
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	sol := venue.Amount{Mantissa: 1_500_000_000, Decimals: 9}
+	usdc := venue.Amount{Mantissa: 2_000_000, Decimals: 6}
+
+	sum, err := sol.Add(usdc)
+	if err != nil || sum != (venue.Amount{Mantissa: 3_500_000_000, Decimals: 9}) {
+		t.Errorf("Add = %+v, %v", sum, err)
+	}
+	diff, err := usdc.Sub(sol)
+	if err != nil || diff != (venue.Amount{Mantissa: 500_000_000, Decimals: 9}) {
+		t.Errorf("Sub = %+v, %v", diff, err)
+	}
+
+	// 1.5 SOL at 20.123456 USDC is 30.185184 USDC
+	price := venue.Amount{Mantissa: 20_123_456, Decimals: 6}
+	notional, err := sol.Mul(price)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notional, err = notional.Rescale(6)
+	if err != nil || notional != (venue.Amount{Mantissa: 30_185_184, Decimals: 6}) {
+		t.Errorf("notional = %+v, %v", notional, err)
+	}
+
+	truncated, err := venue.Amount{Mantissa: 1_999_999_999, Decimals: 9}.Rescale(6)
+	if err != nil || truncated.Mantissa != 1_999_999 {
+		t.Errorf("Rescale = %+v, %v; want truncation to 1_999_999", truncated, err)
+	}
+	if sol.Cmp(usdc) >= 0 || usdc.Cmp(sol) <= 0 || sol.Cmp(venue.Amount{Mantissa: 15, Decimals: 1}) != 0 {
+		t.Error("Cmp does not compare across decimals")
+	}
+}
+
+func TestAmountOverflow(t *testing.T) {
+	if _, err := venue.FromFloat(1e12, 9); !errors.Is(err, venue.ErrAmountOverflow) {
+		t.Errorf("FromFloat: err = %v, want ErrAmountOverflow", err)
+	}
+	if _, err := (venue.Amount{Mantissa: 1e12, Decimals: 0}).Rescale(9); !errors.Is(err, venue.ErrAmountOverflow) {
+		t.Errorf("Rescale: err = %v, want ErrAmountOverflow", err)
+	}
+	large := venue.Amount{Mantissa: 1 << 62, Decimals: 0}
+	if _, err := large.Add(large); !errors.Is(err, venue.ErrAmountOverflow) {
+		t.Errorf("Add: err = %v, want ErrAmountOverflow", err)
+	}
+	if _, err := venue.FromFloat(1, 19); err == nil {
+		t.Error("expected an error for 19 decimals")
+	}
+}